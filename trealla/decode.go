@@ -7,11 +7,19 @@ import (
 	"strings"
 )
 
+// PrologUnmarshaler is implemented by types that can convert a Prolog term into themselves.
+// It is used by [Substitution.Scan] and when decoding compound structs,
+// for struct fields, map values, slice elements, and the compound structs themselves.
+type PrologUnmarshaler interface {
+	UnmarshalProlog(Term) error
+}
+
 var (
-	compoundType = reflect.TypeFor[Compound]()
-	functorType  = reflect.TypeFor[Functor]()
-	termType     = reflect.TypeFor[Term]()
-	atomType     = reflect.TypeFor[Atom]()
+	unmarshalerType = reflect.TypeFor[PrologUnmarshaler]()
+	compoundType    = reflect.TypeFor[Compound]()
+	functorType     = reflect.TypeFor[Functor]()
+	termType        = reflect.TypeFor[Term]()
+	atomType        = reflect.TypeFor[Atom]()
 )
 
 func scan(sub Substitution, rv reflect.Value) error {
@@ -19,6 +27,14 @@ func scan(sub Substitution, rv reflect.Value) error {
 	case reflect.Map:
 		vtype := rv.Type().Elem()
 		for k, v := range sub {
+			if unmarshaler(vtype) {
+				ev := reflect.New(vtype).Elem()
+				if err := convert(ev, reflect.ValueOf(v), reflect.StructField{}); err != nil {
+					return fmt.Errorf("trealla: error converting %q: %w", k, err)
+				}
+				rv.SetMapIndex(reflect.ValueOf(k), ev)
+				continue
+			}
 			vv := reflect.ValueOf(v)
 			if !vv.CanConvert(vtype) {
 				return fmt.Errorf("trealla: invalid element type for Scan: %v", vtype)
@@ -87,6 +103,10 @@ func convert(dstv, srcv reflect.Value, meta reflect.StructField) error {
 
 	ftype := dstv.Type()
 
+	if unmarshaler(ftype) {
+		return unmarshal(dstv, srcv)
+	}
+
 	if ftype == termType {
 		dstv.Set(srcv)
 		return nil
@@ -149,6 +169,28 @@ func convert(dstv, srcv reflect.Value, meta reflect.StructField) error {
 	return nil
 }
 
+// unmarshaler reports whether values of type t (or pointers to them) implement PrologUnmarshaler.
+func unmarshaler(t reflect.Type) bool {
+	if t.Kind() == reflect.Interface {
+		return false
+	}
+	return t.Implements(unmarshalerType) || reflect.PointerTo(t).Implements(unmarshalerType)
+}
+
+// unmarshal calls the UnmarshalProlog method of dstv, which must be settable.
+func unmarshal(dstv, srcv reflect.Value) error {
+	if dstv.Kind() == reflect.Pointer {
+		if dstv.IsNil() {
+			dstv.Set(reflect.New(dstv.Type().Elem()))
+		}
+		return dstv.Interface().(PrologUnmarshaler).UnmarshalProlog(srcv.Interface())
+	}
+	if reflect.PointerTo(dstv.Type()).Implements(unmarshalerType) {
+		return dstv.Addr().Interface().(PrologUnmarshaler).UnmarshalProlog(srcv.Interface())
+	}
+	return dstv.Interface().(PrologUnmarshaler).UnmarshalProlog(srcv.Interface())
+}
+
 // TODO: break out reflect stuff into something like this:
 // type structInfo struct {
 // 	fields   []reflect.Value
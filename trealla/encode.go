@@ -4,16 +4,33 @@ import (
 	"fmt"
 	"math/big"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
+	"unicode"
 )
 
+// PrologMarshaler is implemented by types that can convert themselves into Prolog terms.
+// It is used by [Marshal], and by extension [WithBind] and [WithBinding],
+// including for values nested in compounds, lists, and compound structs.
+// An error from MarshalProlog, including one from a nested value, is returned by the function that called it.
+// MarshalProlog must not return a value of its own type, which is reported as an error.
+type PrologMarshaler interface {
+	MarshalProlog() (Term, error)
+}
+
 // Marshal returns the Prolog text representation of term.
+// Values that implement [PrologMarshaler] are represented by the result of their MarshalProlog method.
 func Marshal(term Term) (string, error) {
 	return marshal(term)
 }
 
 func marshal(term Term) (string, error) {
+	term, err := marshalProlog(term)
+	if err != nil {
+		return "", err
+	}
+
 	switch x := term.(type) {
 	case string:
 		return escapeString(x), nil
@@ -26,9 +43,9 @@ func marshal(term Term) (string, error) {
 	case uint:
 		return strconv.FormatUint(uint64(x), 10), nil
 	case float64:
-		return strconv.FormatFloat(x, 'f', -1, 64), nil
+		return formatFloat(x, 64), nil
 	case float32:
-		return strconv.FormatFloat(float64(x), 'f', -1, 32), nil
+		return formatFloat(float64(x), 32), nil
 	case *big.Int:
 		return x.String(), nil
 	case *big.Rat:
@@ -38,15 +55,15 @@ func marshal(term Term) (string, error) {
 	case Atom:
 		return x.String(), nil
 	case Compound:
-		return x.String(), nil
+		return x.marshal()
 	case Variable:
-		return x.String(), nil
+		return x.marshal()
 	case compoundStruct:
 		c, err := encodeCompoundStruct(term)
 		if err != nil {
 			return "", fmt.Errorf("trealla: error marshaling term %#v: %w", term, err)
 		}
-		return c.String(), nil
+		return c.marshal()
 	case []Term:
 		return marshalSlice(x)
 	case []any:
@@ -102,9 +119,9 @@ func marshal(term Term) (string, error) {
 		case reflect.Uint, reflect.Uint64, reflect.Uint32, reflect.Uint16, reflect.Uint8:
 			return strconv.FormatUint(rv.Uint(), 10), nil
 		case reflect.Float64:
-			return strconv.FormatFloat(rv.Float(), 'f', -1, 64), nil
+			return formatFloat(rv.Float(), 64), nil
 		case reflect.Float32:
-			return strconv.FormatFloat(rv.Float(), 'f', -1, 32), nil
+			return formatFloat(rv.Float(), 32), nil
 		case reflect.String:
 			return escapeString(rv.String()), nil
 		}
@@ -112,6 +129,123 @@ func marshal(term Term) (string, error) {
 	return "", fmt.Errorf("trealla: can't marshal type %T, value: %v", term, term)
 }
 
+// formatFloat formats a float so that it is read back as a float, like 1.0 instead of 1.
+func formatFloat(f float64, bitSize int) string {
+	text := strconv.FormatFloat(f, 'f', -1, bitSize)
+	if strings.Trim(text, "-0123456789") == "" {
+		text += ".0"
+	}
+	return text
+}
+
+// marshalGoal returns the text of goal as a query.
+// Unlike [Marshal], it reports errors from nested terms and ensures variables are valid.
+func marshalGoal(goal Term) (string, error) {
+	goal, err := normalizeGoal(goal)
+	if err != nil {
+		return "", err
+	}
+	text, err := marshal(goal)
+	if err != nil {
+		return "", err
+	}
+	return text + ".", nil
+}
+
+// marshalProlog converts term with its MarshalProlog method, repeating until the result isn't a [PrologMarshaler].
+// It fails instead of looping forever if a MarshalProlog method returns a value of a type that was already converted.
+func marshalProlog(term Term) (Term, error) {
+	var seen []reflect.Type
+	for {
+		m, ok := asMarshaler(term)
+		if !ok {
+			return term, nil
+		}
+		typ := reflect.TypeOf(term)
+		if slices.Contains(seen, typ) {
+			return nil, fmt.Errorf("trealla: error marshaling %T: MarshalProlog returned %T again", seen[0], term)
+		}
+		seen = append(seen, typ)
+		t, err := m.MarshalProlog()
+		if err != nil {
+			return nil, fmt.Errorf("trealla: error marshaling %T: %w", term, err)
+		}
+		term = t
+	}
+}
+
+// asMarshaler returns term as a PrologMarshaler, unless it's a nil pointer.
+func asMarshaler(term Term) (PrologMarshaler, bool) {
+	m, ok := term.(PrologMarshaler)
+	if !ok {
+		return nil, false
+	}
+	if rv := reflect.ValueOf(term); rv.Kind() == reflect.Pointer && rv.IsNil() {
+		return nil, false
+	}
+	return m, true
+}
+
+func normalizeGoal(term Term) (Term, error) {
+	term, err := marshalProlog(term)
+	if err != nil {
+		return nil, err
+	}
+
+	switch x := term.(type) {
+	case Variable:
+		// attributes can't be expressed in query text
+		name := x.Name
+		if name == "" {
+			name = "_"
+		}
+		if !validVariableName(name) {
+			return nil, fmt.Errorf("trealla: invalid variable name: %q", x.Name)
+		}
+		return Variable{Name: name}, nil
+	case Compound:
+		args := make([]Term, len(x.Args))
+		for i, arg := range x.Args {
+			var err error
+			if args[i], err = normalizeGoal(arg); err != nil {
+				return nil, err
+			}
+		}
+		return Compound{Functor: x.Functor, Args: args}, nil
+	case compoundStruct:
+		c, err := encodeCompoundStruct(term)
+		if err != nil {
+			return nil, fmt.Errorf("trealla: error marshaling term %#v: %w", term, err)
+		}
+		return normalizeGoal(c)
+	case []Term:
+		list := make([]Term, len(x))
+		for i, elem := range x {
+			var err error
+			if list[i], err = normalizeGoal(elem); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	}
+	if _, err := marshal(term); err != nil {
+		return nil, err
+	}
+	return term, nil
+}
+
+func validVariableName(name string) bool {
+	for i, r := range name {
+		switch {
+		case i == 0 && r != '_' && !unicode.IsUpper(r):
+			return false
+		case r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			return false
+		}
+	}
+	return name != ""
+}
+
 func marshalSlice[T any](slice []T) (string, error) {
 	var sb strings.Builder
 	sb.WriteRune('[')
@@ -42,6 +42,9 @@ func (err ErrThrow) Error() string {
 	return fmt.Sprintf("trealla: exception thrown: %v", err.Ball)
 }
 
+// ErrMemoryLimit is returned when an interpreter tries to use more memory than allowed by [WithMemoryLimit].
+var ErrMemoryLimit = errors.New("trealla: memory limit exceeded")
+
 func errUnexported(symbol string) error {
 	return fmt.Errorf("trealla: failed to get wasm exported function: %q (symbol not found)", symbol)
 }
@@ -49,6 +49,11 @@ func (pl *prolog) Register(ctx context.Context, name string, arity int, proc Pre
 func (pl *prolog) register(ctx context.Context, name string, arity int, proc Predicate) error {
 	functor := Atom(name)
 	pi := piTerm(functor, arity)
+	if prev, ok := pl.procs[pi.String()]; ok && prev == nil {
+		// restored from a snapshot, the Prolog side is already there
+		pl.procs[pi.String()] = proc
+		return nil
+	}
 	pl.procs[pi.String()] = proc
 	vars := numbervars(arity)
 	head := functor.Of(vars...)
@@ -207,7 +212,7 @@ func hostCall(ctx context.Context, subquery, msgptr, msgsize, reply_pp, replysiz
 	}
 
 	proc, ok := pl.procs[goal.Indicator()]
-	if !ok {
+	if !ok || proc == nil {
 		expr := Atom("throw").Of(
 			Atom("error").Of(
 				Atom("existence_error").Of(Atom("procedure"), goal.pi()),
@@ -223,6 +228,7 @@ func hostCall(ctx context.Context, subquery, msgptr, msgsize, reply_pp, replysiz
 		panic(err)
 	}
 	// log.Println("SAVING", subq.stderr.String())
+	subq.hostcalls++
 
 	locked := &lockedProlog{prolog: pl}
 	continuation := catch(proc, locked, Subquery(subquery), goal)
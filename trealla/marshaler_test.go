@@ -0,0 +1,199 @@
+package trealla_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/trealla-prolog/go/trealla"
+)
+
+// money is represented in Prolog as money(Cents, Currency).
+type money struct {
+	cents    int64
+	currency string
+}
+
+func (m money) MarshalProlog() (trealla.Term, error) {
+	if m.currency == "" {
+		return nil, errors.New("missing currency")
+	}
+	return trealla.Atom("money").Of(m.cents, trealla.Atom(strings.ToLower(m.currency))), nil
+}
+
+func (m *money) UnmarshalProlog(t trealla.Term) error {
+	c, ok := t.(trealla.Compound)
+	if !ok || c.Functor != "money" || len(c.Args) != 2 {
+		return fmt.Errorf("not money: %v", t)
+	}
+	cents, ok1 := c.Args[0].(int64)
+	currency, ok2 := c.Args[1].(trealla.Atom)
+	if !ok1 || !ok2 {
+		return fmt.Errorf("not money: %v", t)
+	}
+	*m = money{cents: cents, currency: strings.ToUpper(string(currency))}
+	return nil
+}
+
+// status is an enum represented as an atom.
+type status int
+
+const (
+	statusOpen status = iota
+	statusClosed
+)
+
+func (s status) MarshalProlog() (trealla.Term, error) {
+	return [...]trealla.Atom{"open", "closed"}[s], nil
+}
+
+func (s *status) UnmarshalProlog(t trealla.Term) error {
+	switch t {
+	case trealla.Atom("open"):
+		*s = statusOpen
+	case trealla.Atom("closed"):
+		*s = statusClosed
+	default:
+		return fmt.Errorf("bad status: %v", t)
+	}
+	return nil
+}
+
+// loop's MarshalProlog returns itself, so marshaling it would never finish.
+type loop struct{}
+
+func (l loop) MarshalProlog() (trealla.Term, error) {
+	return l, nil
+}
+
+type invoice struct {
+	trealla.Functor `prolog:"invoice/3"`
+	ID              int64
+	Total           money
+	Status          *status
+}
+
+func TestPrologMarshaler(t *testing.T) {
+	ctx := context.Background()
+	pl, err := trealla.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pl.Close()
+
+	closed := statusClosed
+	inv := invoice{ID: 1, Total: money{cents: 1250, currency: "EUR"}, Status: &closed}
+
+	t.Run("Marshal", func(t *testing.T) {
+		text, err := trealla.Marshal(inv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "invoice(1, money(1250, eur), closed)"; text != want {
+			t.Errorf("bad marshal. want: %s got: %s", want, text)
+		}
+		text, err = trealla.MarshalOptions{Quoted: true}.Marshal([]trealla.Term{statusOpen, money{cents: 1, currency: "usd"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "[open, money(1, usd)]"; text != want {
+			t.Errorf("bad marshal. want: %s got: %s", want, text)
+		}
+	})
+
+	t.Run("WithBind and Scan", func(t *testing.T) {
+		ans, err := pl.QueryOnce(ctx, "Inv = invoice(ID, money(C0, Cur), S), C is C0 * 2, Total = money(C, Cur), Status = S.",
+			trealla.WithBind("Inv", inv))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result struct {
+			Inv    invoice
+			Total  money
+			Status status
+		}
+		if err := ans.Solution.Scan(&result); err != nil {
+			t.Fatal(err)
+		}
+		if result.Inv.ID != 1 || result.Inv.Total != inv.Total || result.Inv.Status == nil || *result.Inv.Status != statusClosed {
+			t.Errorf("bad invoice: %+v", result.Inv)
+		}
+		if want := (money{cents: 2500, currency: "EUR"}); result.Total != want {
+			t.Errorf("bad total. want: %v got: %v", want, result.Total)
+		}
+		if result.Status != statusClosed {
+			t.Errorf("bad status. want: %v got: %v", statusClosed, result.Status)
+		}
+
+		totals := make(map[string]money)
+		sub := trealla.Substitution{"Total": ans.Solution["Total"]}
+		if err := sub.Scan(&totals); err != nil {
+			t.Fatal(err)
+		}
+		if want := (money{cents: 2500, currency: "EUR"}); totals["Total"] != want {
+			t.Errorf("bad map value. want: %v got: %v", want, totals["Total"])
+		}
+	})
+
+	t.Run("QueryTerm", func(t *testing.T) {
+		q := pl.QueryTerm(ctx, trealla.Atom("=").Of(trealla.Variable{Name: "X"}, statusOpen))
+		defer q.Close()
+		if !q.Next(ctx) {
+			t.Fatal("no answer:", q.Err())
+		}
+		if got := q.Current().Solution["X"]; got != trealla.Atom("open") {
+			t.Errorf("bad answer. want: open got: %v", got)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := pl.QueryOnce(ctx, "true.", trealla.WithBind("X", money{cents: 1}))
+		if err == nil || !strings.Contains(err.Error(), "missing currency") {
+			t.Error("expected marshal error, got:", err)
+		}
+
+		// errors from nested values aren't lost
+		nested := []trealla.Term{
+			trealla.Atom("f").Of(money{cents: 1}),
+			trealla.Atom("/").Of(money{cents: 1}, int64(2)),
+			[]trealla.Term{trealla.Atom("a"), money{cents: 1}},
+			invoice{ID: 1, Total: money{cents: 1}},
+		}
+		for _, term := range nested {
+			if _, err := trealla.Marshal(term); err == nil || !strings.Contains(err.Error(), "missing currency") {
+				t.Errorf("Marshal(%#v): expected marshal error, got: %v", term, err)
+			}
+			if _, err := (trealla.MarshalOptions{}).Marshal(term); err == nil || !strings.Contains(err.Error(), "missing currency") {
+				t.Errorf("MarshalOptions.Marshal(%#v): expected marshal error, got: %v", term, err)
+			}
+			_, err := pl.QueryOnce(ctx, "true.", trealla.WithBind("X", term))
+			if err == nil || !strings.Contains(err.Error(), "missing currency") {
+				t.Errorf("WithBind(%#v): expected marshal error, got: %v", term, err)
+			}
+		}
+		attributed := trealla.Variable{Name: "X", Attr: []trealla.Term{trealla.Atom("f").Of(money{cents: 1})}}
+		if _, err := trealla.Marshal(attributed); err == nil || !strings.Contains(err.Error(), "missing currency") {
+			t.Error("expected marshal error for attribute, got:", err)
+		}
+
+		for _, term := range []trealla.Term{loop{}, trealla.Atom("f").Of(loop{})} {
+			if _, err := trealla.Marshal(term); err == nil {
+				t.Errorf("Marshal(%#v): expected error", term)
+			}
+			if _, err := (trealla.MarshalOptions{}).Marshal(term); err == nil {
+				t.Errorf("MarshalOptions.Marshal(%#v): expected error", term)
+			}
+			if _, err := pl.QueryOnce(ctx, "true.", trealla.WithBind("X", term)); err == nil {
+				t.Errorf("WithBind(%#v): expected error", term)
+			}
+		}
+
+		var result struct{ X money }
+		err = trealla.Substitution{"X": trealla.Atom("free")}.Scan(&result)
+		if err == nil || !strings.Contains(err.Error(), "not money") {
+			t.Error("expected unmarshal error, got:", err)
+		}
+	})
+}
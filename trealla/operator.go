@@ -0,0 +1,181 @@
+package trealla
+
+import (
+	"context"
+	"fmt"
+	"maps"
+)
+
+// Operators is an operator table, like the one managed by op/3 in Prolog.
+// It is used by [MarshalOptions] to write operator terms.
+type Operators struct {
+	prefix  map[Atom]operator
+	infix   map[Atom]operator
+	postfix map[Atom]operator
+}
+
+// StandardOperators returns a copy of the default operator table, the operators Trealla defines at startup.
+func StandardOperators() *Operators {
+	return &Operators{
+		prefix:  maps.Clone(prefixOps),
+		infix:   maps.Clone(infixOps),
+		postfix: make(map[Atom]operator),
+	}
+}
+
+// CurrentOperators returns the operator table of an interpreter, including operators defined by programs with op/3.
+func CurrentOperators(ctx context.Context, pl Prolog) (*Operators, error) {
+	ans, err := pl.QueryOnce(ctx, "findall(op(P, T, N), current_op(P, T, N), Ops).")
+	if err != nil {
+		return nil, err
+	}
+	list, _ := ans.Solution["Ops"].([]Term)
+	ops := &Operators{
+		prefix:  make(map[Atom]operator),
+		infix:   make(map[Atom]operator),
+		postfix: make(map[Atom]operator),
+	}
+	for _, op := range list {
+		c, ok := op.(Compound)
+		if !ok || len(c.Args) != 3 {
+			return nil, fmt.Errorf("trealla: unexpected operator: %v", op)
+		}
+		priority, _ := c.Args[0].(int64)
+		specifier, _ := c.Args[1].(Atom)
+		name, ok := c.Args[2].(Atom)
+		if !ok {
+			// [] is decoded as an empty list
+			if list, isList := c.Args[2].([]Term); isList && len(list) == 0 {
+				name = "[]"
+			}
+		}
+		if name == "" || name[0] == '$' {
+			continue
+		}
+		if err := ops.Add(int(priority), string(specifier), name); err != nil {
+			return nil, err
+		}
+	}
+	return ops, nil
+}
+
+// Add defines an operator, with the same arguments as op/3.
+// Specifier is one of xfx, xfy, yfx, fy, fx, xf, or yf.
+// A priority of 0 removes the operator.
+func (ops *Operators) Add(priority int, specifier string, name Atom) error {
+	if priority < 0 || priority > 1200 {
+		return fmt.Errorf("trealla: invalid operator priority: %d", priority)
+	}
+	kind, ok := opTypes[specifier]
+	if !ok {
+		return fmt.Errorf("trealla: invalid operator specifier: %s", specifier)
+	}
+	var table *map[Atom]operator
+	switch kind {
+	case xfx, xfy, yfx:
+		table = &ops.infix
+	case fy, fx:
+		table = &ops.prefix
+	case xf, yf:
+		table = &ops.postfix
+	}
+	if priority == 0 {
+		delete(*table, name)
+		return nil
+	}
+	if *table == nil {
+		*table = make(map[Atom]operator)
+	}
+	(*table)[name] = operator{priority: priority, kind: kind}
+	return nil
+}
+
+// priority returns the highest priority of the operators named name, or 0 if there are none.
+func (ops *Operators) priority(name Atom) int {
+	return max(ops.prefix[name].priority, ops.infix[name].priority, ops.postfix[name].priority)
+}
+
+type opType int
+
+const (
+	xfx opType = iota
+	xfy
+	yfx
+	fy
+	fx
+	xf
+	yf
+)
+
+var opTypes = map[string]opType{
+	"xfx": xfx,
+	"xfy": xfy,
+	"yfx": yfx,
+	"fy":  fy,
+	"fx":  fx,
+	"xf":  xf,
+	"yf":  yf,
+}
+
+type operator struct {
+	priority int
+	kind     opType
+}
+
+var infixOps = map[Atom]operator{
+	":-":   {1200, xfx},
+	"-->":  {1200, xfx},
+	";":    {1100, xfy},
+	"|":    {1105, xfy},
+	"->":   {1050, xfy},
+	"*->":  {1050, xfy},
+	",":    {1000, xfy},
+	"=":    {700, xfx},
+	`\=`:   {700, xfx},
+	"==":   {700, xfx},
+	`\==`:  {700, xfx},
+	"@<":   {700, xfx},
+	"@>":   {700, xfx},
+	"@=<":  {700, xfx},
+	"@>=":  {700, xfx},
+	"=..":  {700, xfx},
+	"is":   {700, xfx},
+	"=:=":  {700, xfx},
+	`=\=`:  {700, xfx},
+	"<":    {700, xfx},
+	">":    {700, xfx},
+	"=<":   {700, xfx},
+	">=":   {700, xfx},
+	"as":   {700, xfx},
+	":":    {600, xfy},
+	"+":    {500, yfx},
+	"-":    {500, yfx},
+	`/\`:   {500, yfx},
+	`\/`:   {500, yfx},
+	"*":    {400, yfx},
+	"/":    {400, yfx},
+	"//":   {400, yfx},
+	"rem":  {400, yfx},
+	"mod":  {400, yfx},
+	"div":  {400, yfx},
+	"<<":   {400, yfx},
+	">>":   {400, yfx},
+	"rdiv": {400, yfx},
+	"**":   {200, xfx},
+	"^":    {200, xfy},
+}
+
+var prefixOps = map[Atom]operator{
+	":-":        {1200, fx},
+	"?-":        {1200, fx},
+	"attribute": {1199, fx},
+	`\+`:        {900, fy},
+	"-":         {200, fy},
+	"+":         {200, fy},
+	`\`:         {200, fy},
+	"?":         {500, fx},
+	"++":        {100, fy},
+	"--":        {100, fy},
+	"@":         {100, fy},
+	":":         {100, fy},
+}
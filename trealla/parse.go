@@ -0,0 +1,766 @@
+package trealla
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Parse reads a single Prolog term from text, without the need for an interpreter.
+// The terminating period is optional.
+//
+// Terms are represented the same way as in query answers:
+// lists are []Term (the empty list is an empty []Term), double-quoted text is a string,
+// integers are int64 or *big.Int if they overflow, and N rdiv D with integer arguments is a *big.Rat.
+// Parse uses the operators Trealla defines at startup (see [StandardOperators]);
+// operators defined by programs are not known to it.
+func Parse(text string) (Term, error) {
+	p := newParser(text)
+	if p.peek().kind == tokEOF {
+		return nil, p.errorf(p.peek(), "unexpected end of input")
+	}
+	term, err := p.read()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.errorf(tok, "unexpected %s after term", tok)
+	}
+	return term, nil
+}
+
+// ParseAll reads every period-terminated Prolog term (such as the clauses of a program) from text.
+// See [Parse] for how terms are represented.
+func ParseAll(text string) ([]Term, error) {
+	p := newParser(text)
+	var terms []Term
+	for p.peek().kind != tokEOF {
+		term, err := p.read()
+		if err != nil {
+			return terms, err
+		}
+		if tok := p.prev; tok.kind != tokEnd {
+			return terms, p.errorf(p.peek(), "missing period at end of term")
+		}
+		terms = append(terms, term)
+	}
+	return terms, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokEnd           // end of clause: period followed by layout
+	tokName
+	tokQuoted // quoted atom
+	tokVar
+	tokInt
+	tokFloat
+	tokString
+	tokBackquote
+	tokPunct // ( ) [ ] { } , |
+	tokError
+)
+
+type token struct {
+	kind   tokenKind
+	text   string // name, variable name, string contents, or punctuation
+	num    Term   // for numbers
+	layout bool   // preceded by whitespace or comments
+	pos    int    // byte offset
+}
+
+func (tok token) String() string {
+	switch tok.kind {
+	case tokEOF:
+		return "end of input"
+	case tokEnd:
+		return "end of clause"
+	case tokInt, tokFloat:
+		return fmt.Sprint(tok.num)
+	case tokQuoted:
+		return Atom(tok.text).String()
+	case tokString:
+		return escapeString(tok.text)
+	case tokError:
+		return tok.text
+	}
+	return strconv.Quote(tok.text)
+}
+
+type parser struct {
+	text string
+	pos  int
+	buf  []token
+	prev token
+}
+
+func newParser(text string) *parser {
+	return &parser{text: text}
+}
+
+func (p *parser) errorf(tok token, format string, args ...any) error {
+	line := 1 + strings.Count(p.text[:tok.pos], "\n")
+	col := tok.pos - strings.LastIndexByte(p.text[:tok.pos], '\n')
+	return fmt.Errorf("trealla: syntax error at line %d, column %d: %s", line, col, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) peek() token {
+	if len(p.buf) == 0 {
+		p.buf = append(p.buf, p.lex())
+	}
+	return p.buf[0]
+}
+
+func (p *parser) peek2() token {
+	p.peek()
+	if len(p.buf) == 1 {
+		p.buf = append(p.buf, p.lex())
+	}
+	return p.buf[1]
+}
+
+func (p *parser) next() token {
+	tok := p.peek()
+	p.buf = p.buf[1:]
+	p.prev = tok
+	return tok
+}
+
+// read reads a term up to the end of the clause or input.
+func (p *parser) read() (Term, error) {
+	term, err := p.parse(1200)
+	if err != nil {
+		return nil, err
+	}
+	switch tok := p.peek(); tok.kind {
+	case tokEnd:
+		p.next()
+	case tokEOF:
+	default:
+		return nil, p.errorf(tok, "operator expected, got %s", tok)
+	}
+	return term, nil
+}
+
+func (p *parser) parse(max int) (Term, error) {
+	left, prec, err := p.primary(max)
+	if err != nil {
+		return nil, err
+	}
+	return p.infix(left, prec, max)
+}
+
+func (p *parser) infix(left Term, leftPrec, max int) (Term, error) {
+	for {
+		tok := p.peek()
+		var name Atom
+		switch {
+		case tok.kind == tokName || tok.kind == tokQuoted:
+			name = Atom(tok.text)
+		case tok.kind == tokPunct && (tok.text == "," || tok.text == "|"):
+			name = Atom(tok.text)
+		default:
+			return left, nil
+		}
+		op, ok := infixOps[name]
+		if !ok || op.priority > max {
+			return left, nil
+		}
+		leftMax, rightMax := op.priority-1, op.priority-1
+		switch op.kind {
+		case xfy:
+			rightMax = op.priority
+		case yfx:
+			leftMax = op.priority
+		}
+		if leftPrec > leftMax {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parse(rightMax)
+		if err != nil {
+			return nil, err
+		}
+		left = makeInfix(name, left, right)
+		leftPrec = op.priority
+	}
+}
+
+func makeInfix(op Atom, left, right Term) Term {
+	if op == "rdiv" {
+		n, ok1 := toBigInt(left)
+		d, ok2 := toBigInt(right)
+		if ok1 && ok2 && d.Sign() != 0 {
+			return new(big.Rat).SetFrac(n, d)
+		}
+	}
+	return op.Of(left, right)
+}
+
+func toBigInt(x Term) (*big.Int, bool) {
+	switch x := x.(type) {
+	case int64:
+		return big.NewInt(x), true
+	case *big.Int:
+		return x, true
+	}
+	return nil, false
+}
+
+// primary reads a term that isn't the left side of an infix operator, returning it and its priority.
+func (p *parser) primary(max int) (Term, int, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokEOF, tokEnd:
+		return nil, 0, p.errorf(tok, "unexpected %s", tok)
+	case tokError:
+		return nil, 0, p.errorf(tok, "%s", tok.text)
+	case tokInt, tokFloat:
+		return tok.num, 0, nil
+	case tokVar:
+		return Variable{Name: tok.text}, 0, nil
+	case tokString:
+		return tok.text, 0, nil
+	case tokBackquote:
+		codes := make([]Term, 0, len(tok.text))
+		for _, r := range tok.text {
+			codes = append(codes, int64(r))
+		}
+		return codes, 0, nil
+	case tokPunct:
+		switch tok.text {
+		case "(":
+			term, err := p.parse(1200)
+			if err != nil {
+				return nil, 0, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, 0, err
+			}
+			return term, 0, nil
+		case "[":
+			if p.peek().kind == tokPunct && p.peek().text == "]" {
+				p.next()
+				return p.name(Atom("[]"), tok, max)
+			}
+			return p.list()
+		case "{":
+			if p.peek().kind == tokPunct && p.peek().text == "}" {
+				p.next()
+				return p.name(Atom("{}"), tok, max)
+			}
+			term, err := p.parse(1200)
+			if err != nil {
+				return nil, 0, err
+			}
+			if err := p.expect("}"); err != nil {
+				return nil, 0, err
+			}
+			return Atom("{}").Of(term), 0, nil
+		case ",":
+			return nil, 0, p.errorf(tok, "unexpected comma")
+		case "|":
+			// like the interpreter, a bar is only an atom when quoted
+			return nil, 0, p.errorf(tok, "unexpected bar")
+		}
+		return nil, 0, p.errorf(tok, "unexpected %s", tok)
+	case tokName, tokQuoted:
+		if tok.kind == tokName && tok.text == "||" {
+			return nil, 0, p.errorf(tok, "unexpected bar")
+		}
+		// negative numeric literal; like the interpreter, layout may separate the minus from the number
+		if tok.kind == tokName && tok.text == "-" {
+			if next := p.peek(); next.kind == tokInt || next.kind == tokFloat {
+				p.next()
+				return negate(next.num), 0, nil
+			}
+		}
+		return p.name(Atom(tok.text), tok, max)
+	}
+	return nil, 0, p.errorf(tok, "unexpected %s", tok)
+}
+
+// name reads an atom, compound in functional notation, or prefix operator term.
+func (p *parser) name(name Atom, tok token, max int) (Term, int, error) {
+	if next := p.peek(); next.kind == tokPunct && next.text == "(" && !next.layout {
+		p.next()
+		args, err := p.args()
+		if err != nil {
+			return nil, 0, err
+		}
+		return name.Of(args...), 0, nil
+	}
+
+	if name == "[]" && tok.kind == tokPunct {
+		return []Term{}, 0, nil
+	}
+
+	op, ok := prefixOps[name]
+	if !ok || tok.kind == tokQuoted {
+		return name, 0, nil
+	}
+	if !p.startsTerm() {
+		// like the interpreter, an operator as an atom can't be the left operand of an infix operator,
+		// and is only an operand of another operator when followed by a comma or closing bracket
+		if next := p.peek(); max < 1200 && (next.kind == tokEOF || next.kind == tokEnd) {
+			return nil, 0, p.errorf(next, "operand expected after %s, got %s", name, next)
+		}
+		return name, 1201, nil
+	}
+	priority := min(op.priority, max)
+	argMax := priority
+	if op.kind == fx {
+		argMax--
+	}
+	arg, err := p.parse(argMax)
+	if err != nil {
+		return nil, 0, err
+	}
+	return name.Of(arg), priority, nil
+}
+
+// startsTerm reports whether the next token can begin an operand of a prefix operator.
+func (p *parser) startsTerm() bool {
+	next := p.peek()
+	switch next.kind {
+	case tokEOF, tokEnd:
+		return false
+	case tokPunct:
+		return next.text == "(" || next.text == "[" || next.text == "{"
+	case tokName:
+		if _, infix := infixOps[Atom(next.text)]; !infix {
+			return true
+		}
+		if _, prefix := prefixOps[Atom(next.text)]; prefix {
+			return true
+		}
+		// an infix operator used as an atom operand, like - (=), or as a functor, like - =(a, b)
+		after := p.peek2()
+		return after.kind == tokPunct && after.text == "(" && !after.layout
+	}
+	return true
+}
+
+func (p *parser) args() ([]Term, error) {
+	var args []Term
+	for {
+		arg, err := p.parse(999)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		tok := p.next()
+		if tok.kind == tokPunct && tok.text == "," {
+			continue
+		}
+		if tok.kind == tokPunct && tok.text == ")" {
+			return args, nil
+		}
+		return nil, p.errorf(tok, "expected , or ) in arguments, got %s", tok)
+	}
+}
+
+func (p *parser) list() (Term, int, error) {
+	var elems []Term
+	for {
+		elem, err := p.parse(999)
+		if err != nil {
+			return nil, 0, err
+		}
+		elems = append(elems, elem)
+		tok := p.next()
+		if tok.kind != tokPunct {
+			return nil, 0, p.errorf(tok, "expected , | or ] in list, got %s", tok)
+		}
+		switch tok.text {
+		case ",":
+			continue
+		case "]":
+			return elems, 0, nil
+		case "|":
+			tail, err := p.parse(999)
+			if err != nil {
+				return nil, 0, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, 0, err
+			}
+			return consList(elems, tail), 0, nil
+		}
+		return nil, 0, p.errorf(tok, "expected , | or ] in list, got %s", tok)
+	}
+}
+
+// consList builds [elems...|tail].
+func consList(elems []Term, tail Term) Term {
+	if rest, ok := tail.([]Term); ok {
+		return append(elems, rest...)
+	}
+	if str, ok := tail.(string); ok && str == "" {
+		return elems
+	}
+	for i := len(elems) - 1; i >= 0; i-- {
+		tail = Atom(".").Of(elems[i], tail)
+	}
+	return tail
+}
+
+func (p *parser) expect(punct string) error {
+	tok := p.next()
+	if tok.kind != tokPunct || tok.text != punct {
+		return p.errorf(tok, "expected %s, got %s", punct, tok)
+	}
+	return nil
+}
+
+func negate(n Term) Term {
+	switch n := n.(type) {
+	case int64:
+		return -n
+	case *big.Int:
+		neg := new(big.Int).Neg(n)
+		if neg.IsInt64() {
+			return neg.Int64()
+		}
+		return neg
+	case float64:
+		return -n
+	}
+	return n
+}
+
+// lexer
+
+const symbolChars = `+-*/\^<>=~:.?@#&$`
+
+func (p *parser) lex() token {
+	layout, err := p.skipLayout()
+	tok := token{pos: p.pos, layout: layout}
+	if err != nil {
+		return p.lexError(tok, err)
+	}
+	if p.pos >= len(p.text) {
+		tok.kind = tokEOF
+		return tok
+	}
+	r, size := utf8.DecodeRuneInString(p.text[p.pos:])
+	switch {
+	case r == '.' && p.endsClause(p.pos+1):
+		p.pos++
+		tok.kind = tokEnd
+	case r >= '0' && r <= '9':
+		num, isFloat, err := p.number()
+		if err != nil {
+			return p.lexError(tok, err)
+		}
+		tok.kind = tokInt
+		if isFloat {
+			tok.kind = tokFloat
+		}
+		tok.num = num
+	case r == '_' || unicode.IsUpper(r):
+		tok.kind = tokVar
+		tok.text = p.alnum()
+	case unicode.IsLetter(r):
+		tok.kind = tokName
+		tok.text = p.alnum()
+	case r == '\'' || r == '"' || r == '`':
+		text, err := p.quoted(byte(r))
+		if err != nil {
+			return p.lexError(tok, err)
+		}
+		tok.text = text
+		switch r {
+		case '\'':
+			tok.kind = tokQuoted
+		case '"':
+			tok.kind = tokString
+		case '`':
+			tok.kind = tokBackquote
+		}
+	case r == '|' && strings.HasPrefix(p.text[p.pos:], "||"):
+		p.pos += 2
+		tok.kind = tokName
+		tok.text = "||"
+	case strings.ContainsRune("()[]{},|", r):
+		p.pos += size
+		tok.kind = tokPunct
+		tok.text = string(r)
+	case r == '!' || r == ';':
+		p.pos += size
+		tok.kind = tokName
+		tok.text = string(r)
+	case strings.ContainsRune(symbolChars, r):
+		start := p.pos
+		for p.pos < len(p.text) && strings.IndexByte(symbolChars, p.text[p.pos]) >= 0 {
+			p.pos++
+		}
+		tok.kind = tokName
+		tok.text = p.text[start:p.pos]
+	default:
+		return p.lexError(tok, fmt.Errorf("unexpected character %q", r))
+	}
+	return tok
+}
+
+func (p *parser) lexError(tok token, err error) token {
+	// skip the rest of the input
+	p.pos = len(p.text)
+	tok.kind = tokError
+	tok.text = err.Error()
+	return tok
+}
+
+// endsClause reports whether a period before i is an end token.
+func (p *parser) endsClause(i int) bool {
+	if i >= len(p.text) {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(p.text[i:])
+	return unicode.IsSpace(r) || r == '%'
+}
+
+// skipLayout skips whitespace and comments, reporting whether there were any.
+func (p *parser) skipLayout() (bool, error) {
+	start := p.pos
+	for p.pos < len(p.text) {
+		r, size := utf8.DecodeRuneInString(p.text[p.pos:])
+		switch {
+		case unicode.IsSpace(r):
+			p.pos += size
+		case r == '%':
+			end := strings.IndexByte(p.text[p.pos:], '\n')
+			if end == -1 {
+				p.pos = len(p.text)
+			} else {
+				p.pos += end + 1
+			}
+		case strings.HasPrefix(p.text[p.pos:], "/*"):
+			end := strings.Index(p.text[p.pos+2:], "*/")
+			if end == -1 {
+				return true, fmt.Errorf("unterminated block comment")
+			}
+			p.pos += 2 + end + 2
+		default:
+			return p.pos > start, nil
+		}
+	}
+	return p.pos > start, nil
+}
+
+func (p *parser) alnum() string {
+	start := p.pos
+	for p.pos < len(p.text) {
+		r, size := utf8.DecodeRuneInString(p.text[p.pos:])
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			break
+		}
+		p.pos += size
+	}
+	return p.text[start:p.pos]
+}
+
+func (p *parser) number() (Term, bool, error) {
+	text := p.text[p.pos:]
+	if len(text) > 2 && text[0] == '0' {
+		base := 0
+		switch text[1] {
+		case '\'':
+			p.pos += 2
+			code, err := p.charCode()
+			return int64(code), false, err
+		case 'x':
+			base = 16
+		case 'o':
+			base = 8
+		case 'b':
+			base = 2
+		}
+		if base != 0 && isDigit(text[2], base) {
+			end := 2
+			for end < len(text) && isDigit(text[end], base) {
+				end++
+			}
+			p.pos += end
+			return parseInteger(text[2:end], base)
+		}
+	}
+
+	end := 0
+	for end < len(text) && (isDigit(text[end], 10) || text[end] == '_' && end+1 < len(text) && isDigit(text[end+1], 10)) {
+		end++
+	}
+	isFloat := false
+	if end+1 < len(text) && text[end] == '.' && isDigit(text[end+1], 10) {
+		isFloat = true
+		end++
+		for end < len(text) && isDigit(text[end], 10) {
+			end++
+		}
+	}
+	// like the interpreter, an exponent needs a fraction: 1.0e10 is a float, but 1e10 is 1 followed by e10
+	if isFloat && end < len(text) && (text[end] == 'e' || text[end] == 'E') {
+		exp := end + 1
+		if exp < len(text) && (text[exp] == '+' || text[exp] == '-') {
+			exp++
+		}
+		if exp < len(text) && isDigit(text[exp], 10) {
+			isFloat = true
+			end = exp
+			for end < len(text) && isDigit(text[end], 10) {
+				end++
+			}
+		}
+	}
+	p.pos += end
+	digits := strings.ReplaceAll(text[:end], "_", "")
+	if isFloat {
+		f, err := strconv.ParseFloat(digits, 64)
+		return f, true, err
+	}
+	return parseInteger(digits, 10)
+}
+
+func parseInteger(digits string, base int) (Term, bool, error) {
+	if n, err := strconv.ParseInt(digits, base, 64); err == nil {
+		return n, false, nil
+	}
+	n, ok := new(big.Int).SetString(digits, base)
+	if !ok {
+		return nil, false, fmt.Errorf("invalid number %q", digits)
+	}
+	return n, false, nil
+}
+
+func isDigit(c byte, base int) bool {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c-'0') < base
+	case c >= 'a' && c <= 'f':
+		return base == 16
+	case c >= 'A' && c <= 'F':
+		return base == 16
+	}
+	return false
+}
+
+// charCode reads the character of a 0'c literal.
+func (p *parser) charCode() (rune, error) {
+	if p.pos >= len(p.text) {
+		return 0, fmt.Errorf("unexpected end of input in character code")
+	}
+	switch {
+	case strings.HasPrefix(p.text[p.pos:], "''"):
+		p.pos += 2
+		return '\'', nil
+	case p.text[p.pos] == '\'':
+		return 0, fmt.Errorf("quote in character code must be doubled, as in 0'''")
+	case p.text[p.pos] == '\\':
+		r, ok, err := p.escape('\'')
+		if !ok && err == nil {
+			err = fmt.Errorf("invalid character code")
+		}
+		return r, err
+	}
+	r, size := utf8.DecodeRuneInString(p.text[p.pos:])
+	p.pos += size
+	return r, nil
+}
+
+// quoted reads a quoted atom, string, or back-quoted string.
+func (p *parser) quoted(quote byte) (string, error) {
+	start := p.pos
+	p.pos++
+	var sb strings.Builder
+	for {
+		if p.pos >= len(p.text) {
+			p.pos = start
+			return "", fmt.Errorf("unterminated quoted text")
+		}
+		c := p.text[p.pos]
+		switch {
+		case c == quote:
+			if p.pos+1 < len(p.text) && p.text[p.pos+1] == quote {
+				sb.WriteByte(quote)
+				p.pos += 2
+				continue
+			}
+			p.pos++
+			return sb.String(), nil
+		case c == '\\':
+			r, ok, err := p.escape(quote)
+			if err != nil {
+				return "", err
+			}
+			if ok {
+				sb.WriteRune(r)
+			}
+		case c == '\n':
+			return "", fmt.Errorf("newline in quoted text")
+		default:
+			sb.WriteByte(c)
+			p.pos++
+		}
+	}
+}
+
+// escape reads an escape sequence starting at the backslash.
+// It returns false for line continuations, which don't produce a character.
+func (p *parser) escape(quote byte) (rune, bool, error) {
+	p.pos++ // backslash
+	if p.pos >= len(p.text) {
+		return 0, false, fmt.Errorf("unexpected end of input in escape sequence")
+	}
+	c := p.text[p.pos]
+	p.pos++
+	switch c {
+	case 'a':
+		return '\a', true, nil
+	case 'b':
+		return '\b', true, nil
+	case 'f':
+		return '\f', true, nil
+	case 'n':
+		return '\n', true, nil
+	case 'r':
+		return '\r', true, nil
+	case 't':
+		return '\t', true, nil
+	case 'v':
+		return '\v', true, nil
+	case 'e':
+		return 0x1b, true, nil
+	case 's':
+		return ' ', true, nil
+	case '0', '1', '2', '3', '4', '5', '6', '7', 'x':
+		base := 8
+		start := p.pos - 1
+		if c == 'x' {
+			base = 16
+			start = p.pos
+		}
+		end := start
+		for end < len(p.text) && isDigit(p.text[end], base) {
+			end++
+		}
+		if end >= len(p.text) || p.text[end] != '\\' || end == start {
+			return 0, false, fmt.Errorf("invalid escape sequence")
+		}
+		code, err := strconv.ParseInt(p.text[start:end], base, 32)
+		if err != nil || !utf8.ValidRune(rune(code)) {
+			return 0, false, fmt.Errorf("invalid character code in escape sequence")
+		}
+		p.pos = end + 1
+		return rune(code), true, nil
+	case '\\', '\'', '"', '`':
+		return rune(c), true, nil
+	case '\n':
+		return 0, false, nil
+	}
+	return 0, false, fmt.Errorf("invalid escape sequence \\%c", c)
+}
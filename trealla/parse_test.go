@@ -0,0 +1,236 @@
+package trealla_test
+
+import (
+	"context"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/trealla-prolog/go/trealla"
+)
+
+func TestParse(t *testing.T) {
+	bigInt, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	negBig := new(big.Int).Neg(bigInt)
+
+	tests := []struct {
+		text string
+		want trealla.Term
+	}{
+		{"foo", trealla.Atom("foo")},
+		{"foo.", trealla.Atom("foo")},
+		{"'hello world'", trealla.Atom("hello world")},
+		{"[]", []trealla.Term{}},
+		{"'[]'", trealla.Atom("[]")},
+		{"{}", trealla.Atom("{}")},
+		{"X", trealla.Variable{Name: "X"}},
+		{"_Foo", trealla.Variable{Name: "_Foo"}},
+		{"42", int64(42)},
+		{"-42", int64(-42)},
+		{"- 42", int64(-42)},
+		{"1.5e3", 1500.0},
+		{"0x1F", int64(31)},
+		{"0o17", int64(15)},
+		{"0b101", int64(5)},
+		{"0'a", int64('a')},
+		{"0'''", int64('\'')},
+		{`0'\n`, int64('\n')},
+		{"0' ", int64(' ')},
+		{"1_000_000", int64(1000000)},
+		{"123456789012345678901234567890", bigInt},
+		{"-123456789012345678901234567890", negBig},
+		{"1 rdiv 3", big.NewRat(1, 3)},
+		{"-1 rdiv 3", big.NewRat(-1, 3)},
+		{"X rdiv 3", trealla.Atom("rdiv").Of(trealla.Variable{Name: "X"}, int64(3))},
+		{`"abc"`, "abc"},
+		{`"it""s"`, `it"s`},
+		{"`ab`", []trealla.Term{int64('a'), int64('b')}},
+		{`'a\nb\x41\\101\'`, trealla.Atom("a\nbAA")},
+		{`'don''t'`, trealla.Atom("don't")},
+		{"'a\\\nb'", trealla.Atom("ab")},
+		{"f(x, Y, [1, 2])", trealla.Atom("f").Of(trealla.Atom("x"), trealla.Variable{Name: "Y"}, []trealla.Term{int64(1), int64(2)})},
+		{"[a, b|T]", trealla.Atom(".").Of(trealla.Atom("a"), trealla.Atom(".").Of(trealla.Atom("b"), trealla.Variable{Name: "T"}))},
+		{"[a|[b]]", []trealla.Term{trealla.Atom("a"), trealla.Atom("b")}},
+		{"{a, b}", trealla.Atom("{}").Of(trealla.Atom(",").Of(trealla.Atom("a"), trealla.Atom("b")))},
+		{"1 + 2 * 3", trealla.Atom("+").Of(int64(1), trealla.Atom("*").Of(int64(2), int64(3)))},
+		{"1 - 2 - 3", trealla.Atom("-").Of(trealla.Atom("-").Of(int64(1), int64(2)), int64(3))},
+		{"(1 + 2) * 3", trealla.Atom("*").Of(trealla.Atom("+").Of(int64(1), int64(2)), int64(3))},
+		{"2 ^ 3 ^ 4", trealla.Atom("^").Of(int64(2), trealla.Atom("^").Of(int64(3), int64(4)))},
+		{"a - -1", trealla.Atom("-").Of(trealla.Atom("a"), int64(-1))},
+		{"- a", trealla.Atom("-").Of(trealla.Atom("a"))},
+		{"-(1)", trealla.Atom("-").Of(int64(1))},
+		{`\+ a, b`, trealla.Atom(",").Of(trealla.Atom(`\+`).Of(trealla.Atom("a")), trealla.Atom("b"))},
+		{"f(-, a)", trealla.Atom("f").Of(trealla.Atom("-"), trealla.Atom("a"))},
+		{"- .", trealla.Atom("-")},
+		{"1.0e10", 1e10},
+		{"a :- b, c ; d -> e", trealla.Atom(":-").Of(
+			trealla.Atom("a"),
+			trealla.Atom(";").Of(
+				trealla.Atom(",").Of(trealla.Atom("b"), trealla.Atom("c")),
+				trealla.Atom("->").Of(trealla.Atom("d"), trealla.Atom("e"))))},
+		{"a | b", trealla.Atom("|").Of(trealla.Atom("a"), trealla.Atom("b"))},
+		{":- dynamic(foo/1)", trealla.Atom(":-").Of(trealla.Atom("dynamic").Of(trealla.Atom("/").Of(trealla.Atom("foo"), int64(1))))},
+		{"lists:append(X)", trealla.Atom(":").Of(trealla.Atom("lists"), trealla.Atom("append").Of(trealla.Variable{Name: "X"}))},
+		{"f(a, (b, c))", trealla.Atom("f").Of(trealla.Atom("a"), trealla.Atom(",").Of(trealla.Atom("b"), trealla.Atom("c")))},
+		{"X = 'hello' % comment\n", trealla.Atom("=").Of(trealla.Variable{Name: "X"}, trealla.Atom("hello"))},
+		{"/* block */ x", trealla.Atom("x")},
+		{"X is Y mod 2", trealla.Atom("is").Of(trealla.Variable{Name: "X"}, trealla.Atom("mod").Of(trealla.Variable{Name: "Y"}, int64(2)))},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.text, func(t *testing.T) {
+			got, err := trealla.Parse(tc.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("bad parse. want: %#v got: %#v", tc.want, got)
+			}
+		})
+	}
+}
+
+// TestParseInterpreter checks that Parse agrees with the interpreter's reader.
+func TestParseInterpreter(t *testing.T) {
+	pl, err := trealla.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pl.Close()
+	ctx := context.Background()
+
+	texts := []string{
+		"f(a, 'B', \"c\", 1.5, -3)",
+		"[1, 2, 3]",
+		"1 + 2 * 3 - 4 / 5",
+		"a :- b, (c -> d ; e)",
+		"- (1)",
+		"- 1",
+		"- /* comment */ 1.5",
+		"-(-(1))",
+		"- - 1",
+		"2 ** -1",
+		"99999999999999999999999999",
+		"'\\x2603\\ snowman'",
+		"0'\\t",
+		"{x, y}",
+		"a | b",
+		"a:b:c+d",
+		"f(;, '|', [])",
+		"f(a, -)",
+		"f(a = \\)",
+		"a = \\, b",
+	}
+	for _, text := range texts {
+		t.Run(text, func(t *testing.T) {
+			got, err := trealla.Parse(text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ans, err := pl.QueryOnce(ctx, "X = ("+text+").")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := ans.Solution["X"]; !reflect.DeepEqual(got, want) {
+				t.Errorf("mismatch. want: %#v got: %#v", want, got)
+			}
+		})
+	}
+
+	// text that the interpreter's reader rejects must not parse
+	invalid := []string{
+		"1e10",
+		"1E5",
+		"0''",
+		"f(|)",
+		"[|]",
+		"{|}",
+		"f(||)",
+		"a = \\",
+		"a = \\ .",
+		"a :- - .",
+		"- - .",
+		"- = x",
+		"- , a",
+	}
+	for _, text := range invalid {
+		t.Run(text, func(t *testing.T) {
+			if got, err := trealla.Parse(text); err == nil {
+				t.Errorf("expected error, got: %v", got)
+			}
+			_, err := pl.QueryOnce(ctx, "read_term_from_chars(Text, _, []).", trealla.WithBind("Text", text))
+			if err == nil {
+				t.Error("expected the interpreter to reject it")
+			}
+		})
+	}
+}
+
+func TestParseAll(t *testing.T) {
+	const program = `
+		% a small program
+		:- dynamic(counter/1).
+		counter(0).
+		next(N) :-
+			retract(counter(N0)),
+			N is N0 + 1,
+			assertz(counter(N)).
+	`
+	terms, err := trealla.ParseAll(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []trealla.Term{
+		trealla.Atom(":-").Of(trealla.Atom("dynamic").Of(trealla.Atom("/").Of(trealla.Atom("counter"), int64(1)))),
+		trealla.Atom("counter").Of(int64(0)),
+		trealla.Atom(":-").Of(
+			trealla.Atom("next").Of(trealla.Variable{Name: "N"}),
+			trealla.Atom(",").Of(
+				trealla.Atom("retract").Of(trealla.Atom("counter").Of(trealla.Variable{Name: "N0"})),
+				trealla.Atom(",").Of(
+					trealla.Atom("is").Of(trealla.Variable{Name: "N"}, trealla.Atom("+").Of(trealla.Variable{Name: "N0"}, int64(1))),
+					trealla.Atom("assertz").Of(trealla.Atom("counter").Of(trealla.Variable{Name: "N"}))))),
+	}
+	if !reflect.DeepEqual(terms, want) {
+		t.Errorf("bad parse. want: %v got: %v", want, terms)
+	}
+
+	if _, err := trealla.ParseAll("a. b"); err == nil {
+		t.Error("expected error for missing period")
+	}
+}
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"", "unexpected end of input"},
+		{"f(a", "line 1, column 4"},
+		{"f(a,\n b c)", "line 2, column 4"},
+		{"'abc", "unterminated quoted text"},
+		{`'\q'`, "invalid escape sequence"},
+		{"a b", "operator expected"},
+		{"1 = 2 = 3", "operator expected"},
+		{"[a|b|c]", "expected ]"},
+		{"/* abc", "unterminated block comment"},
+		{"a. b.", "after term"},
+		{"1e10", "operator expected"},
+		{"0''", "quote in character code must be doubled"},
+		{"f(|)", "unexpected bar"},
+		{"a = \\", "operand expected"},
+		{"- = x", "operator expected"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.text, func(t *testing.T) {
+			_, err := trealla.Parse(tc.text)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("unexpected error. want substring: %q got: %v", tc.want, err)
+			}
+		})
+	}
+}
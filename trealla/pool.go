@@ -1,29 +1,71 @@
 package trealla
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"iter"
+	"maps"
 	"runtime"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Pool is a pool of Prolog interpreters that distributes read requests to replicas.
+//
+// Pool also implements [Prolog], so it can be used in place of a single interpreter.
+// Queries run on a replica, and methods that modify the knowledgebase run in a write transaction.
 type Pool struct {
 	canon    *prolog
-	children []*prolog
-	idle     chan *prolog
+	children []*replica
+	idle     chan *replica
 	mu       *sync.RWMutex
+	// spawnMu guards children and replaced, which change while readers hold mu
+	spawnMu sync.Mutex
+	// replaced counts broken replicas that were discarded
+	replaced int
+	// out counts replicas checked out of the pool
+	out    sync.WaitGroup
+	closed bool
+	// shrunk is when idle replicas were last checked for closing
+	shrunk time.Time
+
+	// statistics
+	readTxs  atomic.Int64
+	writeTxs atomic.Int64
+	syncTime atomic.Int64 // nanoseconds
+	waits    [len(poolWaitBounds) + 1]atomic.Int64
+
+	// gen is incremented by every write transaction
+	gen uint64
+	// copy of the canonical interpreter's memory as of the last write,
+	// compared against after each write to find the pages it changed
+	base []byte
+	// generation in which each page last changed
+	pagegen []uint64
 
 	// options
-	size int
-	cfg  []Option
+	min         int
+	max         int
+	idleTimeout time.Duration
+	lazy        bool
+	writeLog    io.Writer
+	cfg         []Option
 }
 
 // NewPool creates a new pool with the given options.
 // By default, the pool size will match the number of available CPUs.
+// See [WithPoolMinSize] and [WithPoolMaxSize] for pools that grow and shrink with demand.
 func NewPool(options ...PoolOption) (*Pool, error) {
 	pool := &Pool{
-		size: runtime.NumCPU(),
-		mu:   new(sync.RWMutex),
+		min:         runtime.NumCPU(),
+		max:         runtime.NumCPU(),
+		idleTimeout: time.Minute,
+		mu:          new(sync.RWMutex),
 	}
 	for _, opt := range options {
 		if err := opt(pool); err != nil {
@@ -35,86 +77,723 @@ func NewPool(options ...PoolOption) (*Pool, error) {
 		return nil, err
 	}
 	pool.canon = pl.(*prolog)
-	pool.children = make([]*prolog, pool.size)
-	pool.idle = make(chan *prolog, pool.size)
-	for i := range pool.children {
-		var err error
-		pool.children[i], err = pool.spawn()
+	pool.diff()
+	pool.children = make([]*replica, 0, pool.max)
+	pool.idle = make(chan *replica, pool.max)
+	for range pool.min {
+		child, err := pool.spawn()
 		if err != nil {
 			return nil, err
 		}
-		pool.idle <- pool.children[i]
+		pool.children = append(pool.children, child)
+		pool.idle <- child
 	}
 	return pool, nil
 }
 
 // WriteTx executes a write transaction against this Pool.
 // Use this when modifying the knowledgebase (assert/retract, consulting files, loading modules, and so on).
+// Transactions are not rolled back: if tx returns an error, any changes it made are kept.
+// The exception is a transaction that breaks the interpreter, such as by exceeding [WithMaxInferences]
+// or being stopped by [WithHardStop]: its changes are discarded, and it returns an error.
 func (pool *Pool) WriteTx(tx func(Prolog) error) error {
-	pool.mu.Lock()
+	return pool.WriteTxContext(context.Background(), tx)
+}
+
+// WriteTxContext executes a write transaction against this Pool, like [Pool.WriteTx].
+// If ctx is done before the transaction can begin, it returns an error wrapping ctx.Err().
+// Queries executed within the transaction will also be canceled when ctx is done.
+func (pool *Pool) WriteTxContext(ctx context.Context, tx func(Prolog) error) error {
+	_, err := pool.writeTx(ctx, pool.writeLog != nil, tx)
+	return err
+}
+
+// WriteTxSession executes a write transaction against this Pool, like [Pool.WriteTxContext].
+// It returns a Session that can be passed to [Pool.ReadTxSession] to read the results of this transaction.
+func (pool *Pool) WriteTxSession(ctx context.Context, tx func(Prolog) error) (Session, error) {
+	return pool.writeTx(ctx, pool.writeLog != nil, tx)
+}
+
+func (pool *Pool) writeTx(ctx context.Context, log bool, tx func(Prolog) error) (Session, error) {
+	if err := lockContext(ctx, pool.mu, pool.mu.TryLock); err != nil {
+		return Session{}, err
+	}
 	defer pool.mu.Unlock()
-	pl := &lockedProlog{prolog: pool.canon}
+	if !pool.canon.healthy() {
+		return Session{}, errBrokenCanon
+	}
+	pool.writeTxs.Add(1)
+	if log {
+		pool.canon.txlog = new(txLog)
+		defer func() {
+			pool.canon.txlog = nil
+		}()
+	}
+	pl := &lockedProlog{prolog: pool.canon, ctx: ctx}
 	defer pl.kill()
-	err := tx(pl)
-
-	// Eagerly update the replicas.
-	// This seems to be faster than lazily updating them.
-	if err == nil {
-		for _, child := range pool.children {
-			if err := child.become(pool.canon); err != nil {
-				return err
+	// a failed transaction is not rolled back, so whatever it changed is logged and synced too
+	txErr := tx(pl)
+	if !pool.canon.healthy() {
+		// except when the transaction broke the interpreter, in which case its changes can't be trusted
+		pl.kill()
+		if err := pool.revive(); err != nil {
+			return pool.current(), errors.Join(txErr, err)
+		}
+		return pool.current(), errors.Join(txErr, errors.New("trealla: transaction broke the interpreter, so its changes were discarded"))
+	}
+	if log {
+		if err := pool.canon.txlog.commit(pool.writeLog); err != nil {
+			txErr = errors.Join(txErr, fmt.Errorf("trealla: transaction was applied but couldn't be logged: %w", err))
+		}
+	}
+
+	start := time.Now()
+	pool.gen++
+	pool.diff()
+	pool.syncTime.Add(int64(time.Since(start)))
+	if pool.lazy {
+		return pool.current(), txErr
+	}
+
+	// Eagerly update the idle replicas.
+	// This seems to be faster than lazily updating them, unless the pool is large and rarely read.
+	// Replicas checked out by queries are updated when they are next checked out.
+	idle := make([]*replica, 0, len(pool.idle))
+	defer func() {
+		for _, child := range idle {
+			pool.idle <- child
+		}
+	}()
+	for range len(pool.idle) {
+		select {
+		case child := <-pool.idle:
+			idle = append(idle, child)
+			if err := pool.sync(child); err != nil {
+				return pool.current(), errors.Join(txErr, err)
 			}
+		default:
 		}
 	}
+	return pool.current(), txErr
+}
 
-	return err
+var errBrokenCanon = errors.New("trealla: pool is unusable: its canonical interpreter broke and couldn't be restored")
+
+// revive replaces the broken canonical interpreter with one restored to the state of the last write transaction.
+func (pool *Pool) revive() error {
+	broken := pool.canon
+	canon, err := broken.revive(pool.base)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errBrokenCanon, err)
+	}
+	pool.canon = canon
+	broken.Close()
+	return nil
 }
 
 // ReadTx executes a read transaction against this Pool.
 // Queries in a read transaction must not modify the knowledgebase.
 func (pool *Pool) ReadTx(tx func(Prolog) error) error {
-	pool.mu.RLock()
+	return pool.ReadTxContext(context.Background(), tx)
+}
+
+// ReadTxContext executes a read transaction against this Pool, like [Pool.ReadTx].
+// If ctx is done before a replica becomes available, it returns an error wrapping ctx.Err().
+// Queries executed within the transaction will also be canceled when ctx is done.
+// The transaction always sees the latest state of the Pool.
+func (pool *Pool) ReadTxContext(ctx context.Context, tx func(Prolog) error) error {
+	return pool.readTx(ctx, nil, tx)
+}
+
+// ReadTxSession executes a read transaction against this Pool, like [Pool.ReadTxContext].
+// The transaction sees the Pool's state as of session or later.
+// Unlike [Pool.ReadTxContext], the replica is only brought up to date if it is older than session,
+// so readers that only need their own writes from [Pool.WriteTxSession] can skip catching up
+// on later writes (see [WithLazySync]).
+func (pool *Pool) ReadTxSession(ctx context.Context, session Session, tx func(Prolog) error) error {
+	if session.pool != nil && session.pool != pool {
+		return fmt.Errorf("trealla: session belongs to a different pool")
+	}
+	return pool.readTx(ctx, &session, tx)
+}
+
+// readTx executes a read transaction on a replica at least as recent as session,
+// or the latest state if session is nil.
+func (pool *Pool) readTx(ctx context.Context, session *Session, tx func(Prolog) error) error {
+	if err := lockContext(ctx, pool.mu.RLocker(), pool.mu.TryRLock); err != nil {
+		return err
+	}
 	defer pool.mu.RUnlock()
-	child := pool.child()
+	child, err := pool.child(ctx)
+	if err != nil {
+		return err
+	}
 	defer pool.done(child)
+	pool.readTxs.Add(1)
 	child.mu.Lock()
 	defer child.mu.Unlock()
-	pl := &lockedProlog{prolog: child}
+	if session == nil || child.gen < session.gen {
+		if err := pool.sync(child); err != nil {
+			return err
+		}
+	}
+	child.dirty = true
+	pl := &lockedProlog{prolog: child.prolog, ctx: ctx}
 	defer pl.kill()
-	err := tx(pl)
-	return err
+	return tx(pl)
+}
+
+// Query executes a query on a replica.
+// The replica is checked out of the pool until the query is closed or exhausted.
+// Write transactions don't wait for the query; it keeps seeing the knowledgebase as of when it started.
+func (pool *Pool) Query(ctx context.Context, goal string, options ...QueryOption) Query {
+	if err := lockContext(ctx, pool.mu.RLocker(), pool.mu.TryRLock); err != nil {
+		return failedQuery(err)
+	}
+	child, err := pool.child(ctx)
+	if err != nil {
+		pool.mu.RUnlock()
+		return failedQuery(err)
+	}
+	pool.readTxs.Add(1)
+	child.mu.Lock()
+	err = pool.sync(child)
+	child.dirty = true
+	child.mu.Unlock()
+	pool.mu.RUnlock()
+	if err != nil {
+		pool.done(child)
+		return failedQuery(err)
+	}
+	q := &poolQuery{
+		Query:   child.prolog.Query(ctx, goal, options...),
+		release: func() { pool.done(child) },
+	}
+	runtime.SetFinalizer(q, (*poolQuery).Close)
+	return q
+}
+
+// QueryTerm executes a query given as a term on a replica, like [Pool.Query].
+func (pool *Pool) QueryTerm(ctx context.Context, goal Term, options ...QueryOption) Query {
+	text, err := marshalGoal(goal)
+	if err != nil {
+		return failedQuery(err)
+	}
+	return pool.Query(ctx, text, options...)
+}
+
+// Go executes a query on a replica, sending its results over the returned channel.
+// The replica is checked out of the pool until the results are exhausted or ctx is done.
+func (pool *Pool) Go(ctx context.Context, goal string, options ...QueryOption) <-chan Result {
+	return pool.Query(ctx, goal, options...).Chan(ctx)
+}
+
+// QueryOnce executes a query on a replica, retrieving a single answer and ignoring others.
+func (pool *Pool) QueryOnce(ctx context.Context, goal string, options ...QueryOption) (Answer, error) {
+	var ans Answer
+	err := pool.ReadTxContext(ctx, func(pl Prolog) error {
+		var err error
+		ans, err = pl.QueryOnce(ctx, goal, options...)
+		return err
+	})
+	return ans, err
+}
+
+// Consult loads a Prolog file with the given path in a write transaction.
+func (pool *Pool) Consult(ctx context.Context, filename string) error {
+	return pool.WriteTxContext(ctx, func(pl Prolog) error {
+		return pl.Consult(ctx, filename)
+	})
+}
+
+// ConsultText loads Prolog text into module in a write transaction.
+func (pool *Pool) ConsultText(ctx context.Context, module string, text string) error {
+	return pool.WriteTxContext(ctx, func(pl Prolog) error {
+		return pl.ConsultText(ctx, module, text)
+	})
+}
+
+// Register a native Go predicate in a write transaction.
+func (pool *Pool) Register(ctx context.Context, name string, arity int, predicate Predicate) error {
+	return pool.WriteTxContext(ctx, func(pl Prolog) error {
+		return pl.Register(ctx, name, arity, predicate)
+	})
+}
+
+// RegisterNondet registers a native Go nondeterminate predicate in a write transaction.
+func (pool *Pool) RegisterNondet(ctx context.Context, name string, arity int, predicate NondetPredicate) error {
+	return pool.WriteTxContext(ctx, func(pl Prolog) error {
+		return pl.RegisterNondet(ctx, name, arity, predicate)
+	})
+}
+
+// Clone creates a standalone interpreter from the pool's current state.
+func (pool *Pool) Clone() (Prolog, error) {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	return pool.canon.Clone()
+}
+
+// Snapshot writes the pool's current state to w.
+// Use [Restore] to create a standalone interpreter from it.
+func (pool *Pool) Snapshot(w io.Writer) error {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	return pool.canon.Snapshot(w)
+}
+
+// Close destroys all of the pool's interpreters.
+// It waits for running transactions and queries to finish.
+func (pool *Pool) Close() {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if pool.closed {
+		return
+	}
+	pool.out.Wait()
+	pool.closed = true
+	for _, child := range pool.children {
+		child.Close()
+	}
+	pool.canon.Close()
 }
 
 func (pool *Pool) Stats() Stats {
 	pool.mu.RLock()
 	defer pool.mu.RUnlock()
-	child := pool.child()
+	child, _ := pool.child(context.Background())
 	defer pool.done(child)
 	return child.Stats()
 }
 
-func (pool *Pool) spawn() (*prolog, error) {
-	return pool.canon.clone()
+// Session is a point in the history of a Pool, identified by its generation number.
+// The generation is incremented by every write transaction, including those that return an error.
+// The zero value is the beginning of any Pool's history.
+type Session struct {
+	pool *Pool
+	gen  uint64
+}
+
+// Generation returns the generation number of this session.
+func (s Session) Generation() uint64 {
+	return s.gen
+}
+
+// Session returns the current state of this Pool, for use with [Pool.ReadTxSession].
+func (pool *Pool) Session() Session {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	return pool.current()
+}
+
+// current returns the current Session. The caller must hold a read or write lock.
+func (pool *Pool) current() Session {
+	return Session{pool: pool, gen: pool.gen}
+}
+
+// PoolStats is diagnostic information about a Pool.
+type PoolStats struct {
+	// Replicas is the current number of replicas.
+	Replicas int
+	// Idle is the number of replicas waiting for a transaction.
+	Idle int
+	// Wait is a histogram of the time read transactions spent waiting for a replica.
+	Wait []WaitBucket
+	// ReadTxs is the number of read transactions, including queries made via [Pool.Query].
+	ReadTxs int64
+	// WriteTxs is the number of write transactions.
+	WriteTxs int64
+	// SyncTime is the total time spent updating replicas after write transactions.
+	SyncTime time.Duration
+	// Memory is the memory size of each replica in bytes, as of when it was last returned to the pool.
+	Memory []int
+	// Replaced is the number of replicas that were discarded after breaking
+	// (for example, due to a trap in the interpreter). A fresh clone takes their place when a replica is next needed.
+	Replaced int
+}
+
+// WaitBucket is a bucket of the [PoolStats] wait time histogram.
+type WaitBucket struct {
+	// Max is the upper bound (inclusive) of this bucket. The last bucket has no upper bound and its Max is zero.
+	Max time.Duration
+	// Count is the number of waits that fell into this bucket.
+	Count int64
+}
+
+var poolWaitBounds = [...]time.Duration{
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+}
+
+// PoolStats returns diagnostic information about this Pool.
+func (pool *Pool) PoolStats() PoolStats {
+	pool.spawnMu.Lock()
+	defer pool.spawnMu.Unlock()
+	stats := PoolStats{
+		Replicas: len(pool.children),
+		Idle:     len(pool.idle),
+		Wait:     make([]WaitBucket, len(pool.waits)),
+		ReadTxs:  pool.readTxs.Load(),
+		WriteTxs: pool.writeTxs.Load(),
+		SyncTime: time.Duration(pool.syncTime.Load()),
+		Memory:   make([]int, len(pool.children)),
+		Replaced: pool.replaced,
+	}
+	for i := range pool.waits {
+		if i < len(poolWaitBounds) {
+			stats.Wait[i].Max = poolWaitBounds[i]
+		}
+		stats.Wait[i].Count = pool.waits[i].Load()
+	}
+	for i, child := range pool.children {
+		stats.Memory[i] = int(child.memsize.Load())
+	}
+	return stats
+}
+
+// poolQuery is a query holding a replica checked out from a Pool.
+type poolQuery struct {
+	Query
+	mu      sync.Mutex
+	release func() // nil once the replica is returned
+}
+
+func (q *poolQuery) Next(ctx context.Context) bool {
+	q.mu.Lock()
+	released := q.release == nil
+	q.mu.Unlock()
+	if released {
+		return false
+	}
+	if q.Query.Next(ctx) {
+		return true
+	}
+	q.Close()
+	return false
+}
+
+func (q *poolQuery) All(ctx context.Context) iter.Seq[Answer] {
+	return func(yield func(Answer) bool) {
+		for q.Next(ctx) {
+			if !yield(q.Current()) {
+				break
+			}
+		}
+		q.Close()
+	}
+}
+
+func (q *poolQuery) Chan(ctx context.Context) <-chan Result {
+	return queryChan(ctx, q, nil)
+}
+
+// Close closes the query and returns its replica to the pool.
+func (q *poolQuery) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.release == nil {
+		return nil
+	}
+	err := q.Query.Close()
+	q.release()
+	q.release = nil
+	return err
 }
 
-func (pool *Pool) child() *prolog {
-	return <-pool.idle
+func (q *poolQuery) Stats() QueryStats {
+	return q.Query.(StatsQuery).Stats()
 }
 
-func (pool *Pool) done(child *prolog) {
+func (pool *Pool) spawn() (*replica, error) {
+	pl, err := pool.canon.clone()
+	if err != nil {
+		return nil, err
+	}
+	child := &replica{prolog: pl, gen: pool.gen}
+	child.memsize.Store(int64(pl.memory.Size()))
+	return child, nil
+}
+
+// child checks out a replica, spawning a new one if none are idle and the pool isn't full.
+// The caller must hold a read or write lock.
+func (pool *Pool) child(ctx context.Context) (*replica, error) {
+	child, err := pool.checkout(ctx)
+	if child != nil {
+		pool.out.Add(1)
+	}
+	return child, err
+}
+
+func (pool *Pool) checkout(ctx context.Context) (*replica, error) {
+	start := time.Now()
+	select {
+	case child := <-pool.idle:
+		pool.waited(time.Since(start))
+		return child, nil
+	default:
+	}
+
+	if child, err := pool.grow(); child != nil || err != nil {
+		pool.waited(time.Since(start))
+		return child, err
+	}
+
+	select {
+	case child := <-pool.idle:
+		pool.waited(time.Since(start))
+		return child, nil
+	case <-ctx.Done():
+		return nil, canceled(ctx)
+	}
+}
+
+// waited records the time spent waiting for a replica.
+func (pool *Pool) waited(d time.Duration) {
+	i := 0
+	for i < len(poolWaitBounds) && d > poolWaitBounds[i] {
+		i++
+	}
+	pool.waits[i].Add(1)
+}
+
+// done returns child to the pool, discarding it if it broke.
+// The caller doesn't need to hold a lock.
+func (pool *Pool) done(child *replica) {
+	defer pool.out.Done()
+	if !child.healthy() {
+		pool.discard(child)
+		return
+	}
+	child.idle = time.Now()
+	child.memsize.Store(int64(child.memory.Size()))
 	pool.idle <- child
+	if pool.min < pool.max && pool.idleTimeout > 0 {
+		pool.shrink()
+	}
+}
+
+// discard closes a broken replica and removes it from the pool.
+// A fresh clone takes its place the next time a replica is needed (see [Pool.grow]).
+func (pool *Pool) discard(broken *replica) {
+	pool.spawnMu.Lock()
+	defer pool.spawnMu.Unlock()
+	pool.replaced++
+	broken.Close()
+	pool.children = slices.DeleteFunc(pool.children, func(r *replica) bool { return r == broken })
+}
+
+// grow spawns a new replica if there is room for it.
+// Returns nil if the pool is at its maximum size.
+func (pool *Pool) grow() (*replica, error) {
+	pool.spawnMu.Lock()
+	defer pool.spawnMu.Unlock()
+	if len(pool.children) >= pool.max {
+		return nil, nil
+	}
+	child, err := pool.spawn()
+	if err != nil {
+		return nil, err
+	}
+	pool.children = append(pool.children, child)
+	return child, nil
+}
+
+// shrink closes replicas that have been idle for longer than idleTimeout, down to the minimum pool size.
+// It is called when replicas are returned to the pool, instead of from a background goroutine,
+// so an abandoned Pool can be garbage collected.
+func (pool *Pool) shrink() {
+	pool.spawnMu.Lock()
+	defer pool.spawnMu.Unlock()
+	if time.Since(pool.shrunk) < pool.idleTimeout/2 {
+		return
+	}
+	pool.shrunk = time.Now()
+
+	var keep []*replica
+	for range len(pool.idle) {
+		var child *replica
+		select {
+		case child = <-pool.idle:
+		default:
+		}
+		if child == nil {
+			break
+		}
+		if len(pool.children) > pool.min && time.Since(child.idle) >= pool.idleTimeout {
+			pool.children = slices.DeleteFunc(pool.children, func(r *replica) bool { return r == child })
+			child.Close()
+			continue
+		}
+		keep = append(keep, child)
+	}
+	for _, child := range keep {
+		pool.idle <- child
+	}
+}
+
+// lockContext acquires mu, giving up if ctx is done first.
+func lockContext(ctx context.Context, mu sync.Locker, try func() bool) error {
+	if err := ctx.Err(); err != nil {
+		return canceled(ctx)
+	}
+	if try() {
+		return nil
+	}
+	if ctx.Done() == nil {
+		mu.Lock()
+		return nil
+	}
+	locked := make(chan struct{})
+	go func() {
+		mu.Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		return nil
+	case <-ctx.Done():
+		// we can't abandon the pending Lock, so release it once acquired
+		go func() {
+			<-locked
+			mu.Unlock()
+		}()
+		return canceled(ctx)
+	}
+}
+
+// diff compares the canonical interpreter's memory with its copy from the last write,
+// marking the pages that changed as belonging to the current generation and updating the copy.
+func (pool *Pool) diff() {
+	size := pool.canon.memory.Size()
+	mem, _ := pool.canon.memory.Read(0, size)
+	known := uint32(len(pool.pagegen))
+	pool.base = append(pool.base, mem[len(pool.base):]...)
+	for i := range size / pageSize {
+		// new pages always count as changed, because replicas may have grown their memory differently
+		if i >= known {
+			pool.pagegen = append(pool.pagegen, pool.gen)
+			continue
+		}
+		cur, old := mem[i*pageSize:(i+1)*pageSize], pool.base[i*pageSize:(i+1)*pageSize]
+		if !bytes.Equal(cur, old) {
+			copy(old, cur)
+			pool.pagegen[i] = pool.gen
+		}
+	}
+}
+
+// replica is an interpreter belonging to a Pool.
+type replica struct {
+	*prolog
+	// gen is the generation of the canonical interpreter this replica was last synced to
+	gen uint64
+	// dirty is true if this replica ran queries since its last sync,
+	// meaning its memory may differ from the last synced state in unknown places.
+	dirty bool
+	// idle is when this replica was last returned to the pool
+	idle time.Time
+	// memsize is the size of this replica's memory when it was last returned to the pool
+	memsize atomic.Int64
+}
+
+// sync updates child to match the canonical interpreter, copying only the pages that differ.
+func (pool *Pool) sync(child *replica) error {
+	if child.gen == pool.gen {
+		return nil
+	}
+	start := time.Now()
+	defer func() {
+		pool.syncTime.Add(int64(time.Since(start)))
+	}()
+
+	size, _ := child.memory.Grow(0)
+	if want := uint32(len(pool.pagegen)); want > size {
+		if _, ok := child.memory.Grow(want - size); !ok {
+			return fmt.Errorf("trealla: failed to grow memory to %d bytes: %w", want*pageSize, ErrMemoryLimit)
+		}
+	}
+
+	for i := range uint32(len(pool.pagegen)) {
+		src, _ := pool.canon.memory.Read(i*pageSize, pageSize)
+		dst, _ := child.memory.Read(i*pageSize, pageSize)
+		switch {
+		case pool.pagegen[i] > child.gen:
+			copy(dst, src)
+		case child.dirty:
+			// queries may have written to any page, and wasm can't tell us which, so compare the rest
+			if !bytes.Equal(dst, src) {
+				copy(dst, src)
+			}
+		}
+	}
+	// native predicates registered in write transactions
+	child.procs = maps.Clone(pool.canon.procs)
+	child.gen = pool.gen
+	child.dirty = false
+	return nil
 }
 
 // PoolOption is an option for configuring a Pool.
 type PoolOption func(*Pool) error
 
 // WithPoolSize configures the size (number of replicas) of the Pool.
+// This is equivalent to setting both [WithPoolMinSize] and [WithPoolMaxSize].
 func WithPoolSize(replicas int) PoolOption {
 	return func(pool *Pool) error {
 		if replicas < 1 {
 			return fmt.Errorf("trealla: pool size too low: %d", replicas)
 		}
-		pool.size = replicas
+		pool.min = replicas
+		pool.max = replicas
+		return nil
+	}
+}
+
+// WithPoolMinSize configures the minimum number of replicas of the Pool.
+// These are created up front and are never closed for being idle.
+// If the maximum size is lower, it is raised to match.
+// By default, this is the number of available CPUs.
+func WithPoolMinSize(replicas int) PoolOption {
+	return func(pool *Pool) error {
+		if replicas < 1 {
+			return fmt.Errorf("trealla: pool size too low: %d", replicas)
+		}
+		pool.min = replicas
+		pool.max = max(pool.max, replicas)
+		return nil
+	}
+}
+
+// WithPoolMaxSize configures the maximum number of replicas of the Pool.
+// When every replica is busy, new ones are spawned on demand until this limit is reached.
+// If the minimum size is higher, it is lowered to match.
+// By default, this is the number of available CPUs.
+func WithPoolMaxSize(replicas int) PoolOption {
+	return func(pool *Pool) error {
+		if replicas < 1 {
+			return fmt.Errorf("trealla: pool size too low: %d", replicas)
+		}
+		pool.max = replicas
+		pool.min = min(pool.min, replicas)
+		return nil
+	}
+}
+
+// WithPoolIdleTimeout configures how long a replica can sit idle before it is closed,
+// for pools whose minimum size is lower than their maximum size.
+// Idle replicas are closed when another replica is returned to the pool,
+// so a pool that isn't being used keeps its current size.
+// Zero disables closing idle replicas. The default is one minute.
+func WithPoolIdleTimeout(timeout time.Duration) PoolOption {
+	return func(pool *Pool) error {
+		if timeout < 0 {
+			return fmt.Errorf("trealla: invalid pool idle timeout: %v", timeout)
+		}
+		pool.idleTimeout = timeout
 		return nil
 	}
 }
@@ -126,3 +805,15 @@ func WithPoolPrologOption(options ...Option) PoolOption {
 		return nil
 	}
 }
+
+// WithLazySync configures the Pool to update replicas lazily.
+// Write transactions only modify the canonical interpreter, and replicas catch up
+// when they are next used for a read transaction.
+// This can be faster for large pools whose replicas are rarely read.
+// By default, all replicas are updated at the end of each write transaction.
+func WithLazySync() PoolOption {
+	return func(pool *Pool) error {
+		pool.lazy = true
+		return nil
+	}
+}
@@ -1,10 +1,14 @@
 package trealla
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"reflect"
 	"runtime"
 	"sync"
 	"testing"
+	"time"
 )
 
 const concurrency = 100
@@ -40,6 +44,738 @@ func TestPool(t *testing.T) {
 	wg.Wait()
 }
 
+func TestPoolSync(t *testing.T) {
+	t.Run("eager", func(t *testing.T) {
+		testPoolSync(t)
+	})
+	t.Run("lazy", func(t *testing.T) {
+		testPoolSync(t, WithLazySync())
+	})
+}
+
+func testPoolSync(t *testing.T, options ...PoolOption) {
+	ctx := context.Background()
+	pool, err := NewPool(append([]PoolOption{WithPoolSize(2)}, options...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(t *testing.T, want int64) {
+		t.Helper()
+		if pool.lazy {
+			// replicas are only updated when read
+			for _, child := range pool.children {
+				if child.gen == pool.gen {
+					t.Error("replica was synced before being read")
+				}
+			}
+			if err := pool.sync(pool.children[0]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		size := pool.canon.memory.Size()
+		canon, _ := pool.canon.memory.Read(0, size)
+		if !bytes.Equal(canon, pool.base) {
+			t.Error("copy of canonical memory is stale")
+		}
+		for i, child := range pool.children {
+			if child.gen != pool.gen {
+				continue
+			}
+			mem, _ := child.memory.Read(0, size)
+			if !bytes.Equal(canon, mem) {
+				t.Error("replica", i, "memory differs from canon")
+			}
+		}
+		for range pool.children {
+			err := pool.ReadTx(func(pl Prolog) error {
+				ans, err := pl.QueryOnce(ctx, "findall(N, (findall(X, fact(X), Xs), length(Xs, N)), [N]).")
+				if err != nil {
+					return err
+				}
+				if got := ans.Solution["N"]; got != want {
+					t.Error("unexpected fact count. want:", want, "got:", got)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	write := func(t *testing.T, text string) {
+		t.Helper()
+		err := pool.WriteTx(func(pl Prolog) error {
+			return pl.ConsultText(ctx, "user", text)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(t, ":- dynamic(fact/1). fact(1).")
+	check(t, 1)
+
+	// replicas were dirtied by the reads in check
+	write(t, "fact(2).")
+	changed := 0
+	for _, gen := range pool.pagegen {
+		if gen == pool.gen {
+			changed++
+		}
+	}
+	if changed == 0 || changed > len(pool.pagegen)/2 {
+		t.Errorf("unexpected number of changed pages: %d of %d", changed, len(pool.pagegen))
+	}
+	check(t, 2)
+
+	// grow the canonical interpreter's memory
+	err = pool.WriteTx(func(pl Prolog) error {
+		_, err := pl.QueryOnce(ctx, "between(3, 100000, N), assertz(fact(N)), fail ; true.")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, 100000)
+}
+
+func TestPoolContext(t *testing.T) {
+	pool, err := NewPool(WithPoolSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// hold the only replica until release is closed
+	hold := func() (release func()) {
+		held := make(chan struct{})
+		done := make(chan struct{})
+		finished := make(chan struct{})
+		go func() {
+			defer close(finished)
+			pool.ReadTx(func(_ Prolog) error {
+				close(held)
+				<-done
+				return nil
+			})
+		}()
+		<-held
+		return func() {
+			close(done)
+			<-finished
+		}
+	}
+
+	t.Run("read waiting for replica", func(t *testing.T) {
+		release := hold()
+		defer release()
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err := pool.ReadTxContext(ctx, func(_ Prolog) error {
+			t.Error("transaction ran")
+			return nil
+		})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Error("unexpected error:", err)
+		}
+	})
+
+	t.Run("write waiting for lock", func(t *testing.T) {
+		release := hold()
+		defer release()
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err := pool.WriteTxContext(ctx, func(_ Prolog) error {
+			t.Error("transaction ran")
+			return nil
+		})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Error("unexpected error:", err)
+		}
+	})
+
+	t.Run("query in transaction", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err := pool.ReadTxContext(ctx, func(pl Prolog) error {
+			_, err := pl.QueryOnce(context.Background(), "repeat, fail.")
+			return err
+		})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Error("unexpected error:", err)
+		}
+	})
+
+	t.Run("still usable", func(t *testing.T) {
+		err := pool.WriteTxContext(context.Background(), func(pl Prolog) error {
+			return pl.ConsultText(context.Background(), "user", "ok.")
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = pool.ReadTxContext(context.Background(), func(pl Prolog) error {
+			_, err := pl.QueryOnce(context.Background(), "ok.")
+			return err
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestPoolProlog(t *testing.T) {
+	ctx := context.Background()
+	pool, err := NewPool(WithPoolSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	var pl Prolog = pool
+
+	if err := pl.ConsultText(ctx, "user", "fact(1). fact(2). fact(3)."); err != nil {
+		t.Fatal(err)
+	}
+	err = pl.Register(ctx, "double", 2, func(_ Prolog, _ Subquery, goal Term) Term {
+		g := goal.(Compound)
+		return Atom("double").Of(g.Args[0], g.Args[0].(int64)*2)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("exhausted", func(t *testing.T) {
+		q := pl.Query(ctx, "fact(X), double(X, Y).")
+		var ys []Term
+		for q.Next(ctx) {
+			ys = append(ys, q.Current().Solution["Y"])
+		}
+		if err := q.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(ys, []Term{int64(2), int64(4), int64(6)}) {
+			t.Error("unexpected answers:", ys)
+		}
+		// the only replica should be available again
+		if _, err := pl.QueryOnce(ctx, "fact(1)."); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		q := pl.Query(ctx, "fact(X).")
+		if !q.Next(ctx) {
+			t.Fatal(q.Err())
+		}
+		if err := q.Close(); err != nil {
+			t.Error(err)
+		}
+		if q.Next(ctx) {
+			t.Error("closed query returned an answer")
+		}
+		if err := pl.ConsultText(ctx, "user", "fact(4)."); err != nil {
+			t.Error(err)
+		}
+		if _, err := pl.QueryOnce(ctx, "fact(4)."); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		q := pl.Query(ctx, "fact(X).")
+		defer q.Close()
+		if !q.Next(ctx) {
+			t.Fatal(q.Err())
+		}
+		// q is holding the only replica
+		timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		if _, err := pl.QueryOnce(timeout, "fact(1)."); !errors.Is(err, context.DeadlineExceeded) {
+			t.Error("unexpected error:", err)
+		}
+	})
+}
+
+func TestPoolTxChan(t *testing.T) {
+	ctx := context.Background()
+	pool, err := NewPool(WithPoolSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	var ch <-chan Result
+	err = pool.ReadTx(func(pl Prolog) error {
+		ch = pl.Go(ctx, "repeat.")
+		result := <-ch
+		return result.Err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the transaction stopped the channel's goroutine before returning the replica
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Error("channel query outlived its transaction")
+		}
+	default:
+		t.Error("channel wasn't closed")
+	}
+	if _, err := pool.QueryOnce(ctx, "true."); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPoolTxChanConcurrent(t *testing.T) {
+	ctx := context.Background()
+	pool, err := NewPool(WithPoolSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	for _, tx := range []func(func(Prolog) error) error{pool.ReadTx, pool.WriteTx} {
+		err = tx(func(pl Prolog) error {
+			ch := pl.Go(ctx, "between(1, 500, X).")
+			// drain the channel, running the interpreter from its goroutine, while we query it here
+			n := make(chan int)
+			go func() {
+				count := 0
+				for result := range ch {
+					if result.Err != nil {
+						t.Error(result.Err)
+					}
+					count++
+				}
+				n <- count
+			}()
+			for i := 0; i < 100; i++ {
+				if _, err := pl.QueryOnce(ctx, "atom_length(abc, 3)."); err != nil {
+					return err
+				}
+			}
+			if got := <-n; got != 500 {
+				t.Error("unexpected number of results. want: 500 got:", got)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPoolQueryNested(t *testing.T) {
+	ctx := context.Background()
+	pool, err := NewPool(WithPoolSize(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	if err := pool.ConsultText(ctx, "user", ":- dynamic(fact/1). fact(1). fact(2). fact(3)."); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		q := pool.Query(ctx, "fact(X).")
+		defer q.Close()
+		var n int
+		for q.Next(ctx) {
+			if n == 0 {
+				// a write transaction starts while this query holds a replica
+				written := make(chan struct{})
+				go func() {
+					defer close(written)
+					if err := pool.ConsultText(ctx, "user", "fact(4)."); err != nil {
+						t.Error(err)
+					}
+				}()
+				<-written
+			}
+			n++
+			if _, err := pool.QueryOnce(ctx, "fact(4)."); err != nil {
+				t.Error(err)
+			}
+		}
+		if err := q.Err(); err != nil {
+			t.Error(err)
+		}
+		// the query keeps seeing the knowledgebase as of when it started
+		if n != 3 {
+			t.Error("unexpected number of answers. want: 3 got:", n)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("deadlock")
+	}
+}
+
+func TestPoolElastic(t *testing.T) {
+	ctx := context.Background()
+	pool, err := NewPool(WithPoolMinSize(1), WithPoolMaxSize(3), WithPoolIdleTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	replicas := func() int {
+		pool.spawnMu.Lock()
+		defer pool.spawnMu.Unlock()
+		return len(pool.children)
+	}
+	if n := replicas(); n != 1 {
+		t.Fatal("unexpected initial size:", n)
+	}
+
+	if err := pool.ConsultText(ctx, "user", "fact(1). fact(2)."); err != nil {
+		t.Fatal(err)
+	}
+
+	// each query holds a replica until closed
+	var queries []Query
+	for range 3 {
+		q := pool.Query(ctx, "fact(X).")
+		if !q.Next(ctx) {
+			t.Fatal(q.Err())
+		}
+		queries = append(queries, q)
+	}
+	if n := replicas(); n != 3 {
+		t.Error("pool didn't grow. want: 3 got:", n)
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := pool.QueryOnce(timeout, "fact(1)."); !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("pool grew past its maximum size:", err)
+	}
+
+	for _, q := range queries {
+		q.Close()
+	}
+	time.Sleep(100 * time.Millisecond)
+	// idle replicas are closed when one is returned
+	if _, err := pool.QueryOnce(ctx, "fact(2)."); err != nil {
+		t.Error(err)
+	}
+	if n := replicas(); n != 1 {
+		t.Error("pool didn't shrink. want: 1 got:", n)
+	}
+}
+
+func TestPoolReplace(t *testing.T) {
+	ctx := context.Background()
+	pool, err := NewPool(WithPoolSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	if err := pool.ConsultText(ctx, "user", "fact(1)."); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(t *testing.T, replaced int) {
+		t.Helper()
+		err := pool.ReadTx(func(pl Prolog) error {
+			_, err := pl.QueryOnce(ctx, "fact(1).")
+			return err
+		})
+		if err != nil {
+			t.Error("replica wasn't replaced:", err)
+		}
+		if got := pool.PoolStats().Replaced; got != replaced {
+			t.Error("unexpected replaced count. want:", replaced, "got:", got)
+		}
+	}
+
+	t.Run("closed", func(t *testing.T) {
+		pool.ReadTx(func(pl Prolog) error {
+			pl.Close()
+			return nil
+		})
+		check(t, 1)
+	})
+
+	t.Run("corrupted", func(t *testing.T) {
+		err := pool.ReadTx(func(pl Prolog) error {
+			// trash the interpreter's memory
+			mem := pl.(*lockedProlog).prolog.memory
+			buf, _ := mem.Read(0, mem.Size())
+			clear(buf)
+			_, err := pl.QueryOnce(ctx, "fact(1).")
+			return err
+		})
+		if err == nil {
+			t.Fatal("expected error from broken interpreter")
+		}
+		check(t, 2)
+	})
+}
+
+func TestPoolReviveCanon(t *testing.T) {
+	ctx := context.Background()
+	var log bytes.Buffer
+	pool, err := NewPool(WithPoolSize(1), WithPoolPrologOption(WithInferenceCounter()), WithWriteLog(&log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	if err := pool.ConsultText(ctx, "user", ":- dynamic(fact/1). fact(1). l2 :- l2."); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(t *testing.T, want []Term) {
+		t.Helper()
+		if !pool.canon.healthy() {
+			t.Fatal("canonical interpreter wasn't replaced")
+		}
+		ans, err := pool.QueryOnce(ctx, "findall(X, fact(X), Xs).")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ans.Solution["Xs"]; !reflect.DeepEqual(got, want) {
+			t.Error("unexpected facts. want:", want, "got:", got)
+		}
+	}
+
+	breakers := map[string]func(pl Prolog) error{
+		"corrupted": func(pl Prolog) error {
+			// trash the interpreter's memory
+			mem := pl.(*lockedProlog).prolog.memory
+			buf, _ := mem.Read(0, mem.Size())
+			clear(buf)
+			_, err := pl.QueryOnce(ctx, "fact(1).")
+			return err
+		},
+		"inference limit": func(pl Prolog) error {
+			_, err := pl.QueryOnce(ctx, "l2.", WithMaxInferences(1_000_000))
+			return err
+		},
+	}
+	for name, breaker := range breakers {
+		t.Run(name, func(t *testing.T) {
+			logged := log.Len()
+			err := pool.WriteTx(func(pl Prolog) error {
+				if _, err := pl.QueryOnce(ctx, "assertz(fact(2))."); err != nil {
+					return err
+				}
+				return breaker(pl)
+			})
+			if err == nil {
+				t.Fatal("expected error from broken interpreter")
+			}
+			// the transaction's changes were discarded
+			check(t, []Term{int64(1)})
+			if log.Len() != logged {
+				t.Error("discarded transaction was logged")
+			}
+		})
+	}
+
+	// the pool still accepts writes
+	if _, err := pool.QueryOnce(ctx, "true."); err != nil {
+		t.Fatal(err)
+	}
+	err = pool.WriteTx(func(pl Prolog) error {
+		_, err := pl.QueryOnce(ctx, "assertz(fact(3)).")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, []Term{int64(1), int64(3)})
+}
+
+func TestPoolStats(t *testing.T) {
+	ctx := context.Background()
+	pool, err := NewPool(WithPoolSize(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	if err := pool.ConsultText(ctx, "user", "fact(1)."); err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		if _, err := pool.QueryOnce(ctx, "fact(1)."); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := pool.PoolStats()
+	if stats.Replicas != 2 || stats.Idle != 2 {
+		t.Error("unexpected replica counts:", stats.Replicas, stats.Idle)
+	}
+	if stats.ReadTxs != 3 || stats.WriteTxs != 1 {
+		t.Error("unexpected transaction counts:", stats.ReadTxs, stats.WriteTxs)
+	}
+	if stats.SyncTime <= 0 {
+		t.Error("sync time not recorded")
+	}
+	var waits int64
+	for _, bucket := range stats.Wait {
+		waits += bucket.Count
+	}
+	if waits != 3 {
+		t.Error("unexpected number of waits. want: 3 got:", waits)
+	}
+	if len(stats.Memory) != 2 {
+		t.Fatal("unexpected number of memory sizes:", stats.Memory)
+	}
+	for _, size := range stats.Memory {
+		if size <= 0 || size%pageSize != 0 {
+			t.Error("bad memory size:", size)
+		}
+	}
+}
+
+func TestPoolSession(t *testing.T) {
+	ctx := context.Background()
+	pool, err := NewPool(WithPoolSize(2), WithLazySync())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	before := pool.Session()
+	session, err := pool.WriteTxSession(ctx, func(pl Prolog) error {
+		return pl.ConsultText(ctx, "user", "fact(1).")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Generation() != before.Generation()+1 {
+		t.Error("unexpected generation. want:", before.Generation()+1, "got:", session.Generation())
+	}
+
+	for range pool.children {
+		err := pool.ReadTxSession(ctx, session, func(pl Prolog) error {
+			_, err := pl.QueryOnce(ctx, "fact(1).")
+			return err
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	// replicas that are as recent as the session aren't brought up to date
+	later, err := pool.WriteTxSession(ctx, func(pl Prolog) error {
+		return pl.ConsultText(ctx, "user", "fact(3).")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pool.ReadTxSession(ctx, session, func(pl Prolog) error {
+		if _, err := pl.QueryOnce(ctx, "fact(3)."); err == nil {
+			t.Error("replica was synced past the session")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	err = pool.ReadTxContext(ctx, func(pl Prolog) error {
+		_, err := pl.QueryOnce(ctx, "fact(3).")
+		return err
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	// failed transactions keep their changes, so they count as a new generation
+	failed, err := pool.WriteTxSession(ctx, func(pl Prolog) error {
+		if err := pl.ConsultText(ctx, "user", "fact(2)."); err != nil {
+			return err
+		}
+		return errors.New("failed")
+	})
+	if err == nil {
+		t.Error("expected error")
+	}
+	if failed.Generation() != later.Generation()+1 {
+		t.Error("unexpected generation. want:", later.Generation()+1, "got:", failed.Generation())
+	}
+	err = pool.ReadTxSession(ctx, failed, func(pl Prolog) error {
+		_, err := pl.QueryOnce(ctx, "fact(2).")
+		return err
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	other, err := NewPool(WithPoolSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if err := other.ReadTxSession(ctx, session, func(Prolog) error { return nil }); err == nil {
+		t.Error("expected error for session from another pool")
+	}
+}
+
+func BenchmarkPoolWriteTx(b *testing.B) {
+	b.Run("eager", func(b *testing.B) {
+		benchmarkPoolWriteTx(b, 0)
+	})
+	b.Run("lazy", func(b *testing.B) {
+		benchmarkPoolWriteTx(b, 0, WithLazySync())
+	})
+}
+
+// BenchmarkPoolWriteRead reads from every replica after each write, so every replica is dirty when synced.
+func BenchmarkPoolWriteRead(b *testing.B) {
+	b.Run("eager", func(b *testing.B) {
+		benchmarkPoolWriteTx(b, 8)
+	})
+	b.Run("lazy", func(b *testing.B) {
+		benchmarkPoolWriteTx(b, 8, WithLazySync())
+	})
+}
+
+// benchmarkPoolWriteTx measures a write transaction followed by the given number of read transactions.
+func benchmarkPoolWriteTx(b *testing.B, reads int, options ...PoolOption) {
+	b.Helper()
+	ctx := context.Background()
+	pool, err := NewPool(append([]PoolOption{WithPoolSize(8)}, options...)...)
+	if err != nil {
+		b.Fatal(err)
+	}
+	err = pool.WriteTx(func(pl Prolog) error {
+		_, err := pl.QueryOnce(ctx, "between(1, 100000, N), assertz(fact(N)), fail ; true.")
+		return err
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		err := pool.WriteTx(func(pl Prolog) error {
+			_, err := pl.QueryOnce(ctx, "assertz(counter(1)).")
+			return err
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		for i := 0; i < reads; i++ {
+			err := pool.ReadTx(func(pl Prolog) error {
+				_, err := pl.QueryOnce(ctx, "counter(_), !.")
+				return err
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
 func BenchmarkPool4(b *testing.B) {
 	benchmarkPool(b, 4)
 }
@@ -0,0 +1,192 @@
+package trealla
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// PreparedQuery is a query goal that was parsed once by [Prolog.Prepare] and can be executed many times.
+// A PreparedQuery made from the Prolog passed to a [Pool] transaction stops working once that transaction ends;
+// use [Pool.Prepare] for queries that outlive it.
+type PreparedQuery struct {
+	pl    Prolog
+	write func(context.Context, func(Prolog) error) error
+	head  Atom
+	vars  []string
+}
+
+func (pl *prolog) Prepare(ctx context.Context, goal string) (*PreparedQuery, error) {
+	return prepareDirect(ctx, pl, goal)
+}
+
+func (pl *lockedProlog) Prepare(ctx context.Context, goal string) (*PreparedQuery, error) {
+	if err := pl.ensure(); err != nil {
+		return nil, err
+	}
+	return prepareDirect(ctx, pl, goal)
+}
+
+// Prepare compiles goal in a write transaction.
+// Executing the returned query runs it on a replica, like [Pool.Query].
+func (pool *Pool) Prepare(ctx context.Context, goal string) (*PreparedQuery, error) {
+	var pq *PreparedQuery
+	err := pool.WriteTxContext(ctx, func(pl Prolog) error {
+		var err error
+		pq, err = prepare(ctx, pl, goal)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	pq.pl = pool
+	pq.write = pool.WriteTxContext
+	return pq, nil
+}
+
+func prepareDirect(ctx context.Context, pl Prolog, goal string) (*PreparedQuery, error) {
+	pq, err := prepare(ctx, pl, goal)
+	if err != nil {
+		return nil, err
+	}
+	pq.pl = pl
+	pq.write = func(_ context.Context, tx func(Prolog) error) error {
+		return tx(pl)
+	}
+	return pq, nil
+}
+
+// prepare compiles goal into a temporary clause whose head arguments are the goal's variables.
+// The clause's name is random, so it can't clash with one carried over by [Restore] or [Pool.ReplayLog].
+func prepare(ctx context.Context, pl Prolog, goal string) (*PreparedQuery, error) {
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, fmt.Errorf("trealla: failed to prepare query: %w", err)
+	}
+	head := Atom(fmt.Sprintf("$prepared_%x", nonce))
+	compile := fmt.Sprintf(
+		"read_term_from_atom(%s, G, [variable_names(Vs)]), findall(N, member(N=_, Vs), Names), maplist(arg(2), Vs, Args), Head =.. [%s|Args], assertz((Head :- G)), "+
+			"( current_predicate('$prepared_ask'/2) -> true ; assertz((%s)) ).",
+		Atom(goal).String(), head.String(), preparedAsk)
+	ans, err := pl.QueryOnce(ctx, compile)
+	if err != nil {
+		return nil, fmt.Errorf("trealla: failed to prepare query: %w", err)
+	}
+	pq := &PreparedQuery{head: head}
+	switch names := ans.Solution["Names"].(type) {
+	case string:
+		// a list of single-character names, such as [X, Y], is decoded as a string
+		for _, name := range names {
+			pq.vars = append(pq.vars, string(name))
+		}
+	case []Term:
+		pq.vars = make([]string, len(names))
+		for i, name := range names {
+			switch name := name.(type) {
+			case Atom:
+				pq.vars[i] = string(name)
+			case string:
+				pq.vars[i] = name
+			}
+		}
+	}
+	return pq, nil
+}
+
+// Vars returns the names of the variables of this query, in order of appearance.
+func (pq *PreparedQuery) Vars() []string {
+	return pq.vars
+}
+
+// Exec executes this query. Variables with a value in subs are bound to it,
+// and the rest appear in the solutions of answers as usual.
+func (pq *PreparedQuery) Exec(ctx context.Context, subs Substitution, options ...QueryOption) Query {
+	args := make([]Term, len(pq.vars))
+	var free []string
+	for i, name := range pq.vars {
+		if v, ok := subs[name]; ok {
+			args[i] = v
+			continue
+		}
+		args[i] = Variable{Name: name}
+		free = append(free, name)
+	}
+	// the interpreter can't call a bare atom starting with $, so it goes in a conjunction
+	var call Term = Atom(",").Of(Atom("true"), pq.head)
+	if len(args) > 0 {
+		call = pq.head.Of(args...)
+	}
+	goal, err := marshal(call)
+	if err != nil {
+		return failedQuery(err)
+	}
+	options = append(options, withPrepared(free))
+	return pq.pl.Query(ctx, goal, options...)
+}
+
+// preparedAsk is the clause of '$prepared_ask'(Goal, Vars), which works like '$json_ask'/1
+// but takes a goal that has already been read along with its variable names.
+const preparedAsk = `'$prepared_ask'(Query, Vars) :-
+	'$silent_toplevel',
+	catch(
+		(   call(Query)
+		*-> Status = success
+		;   Status = failure
+		),
+		Error,
+		Status = error
+	),
+	setup_call_cleanup(
+		(   '$yield_off',
+			'$memory_stream_create'(Stream, [])
+		),
+		(   wasm:result_json(Status, Stream, Vars, Error),
+			'$memory_stream_to_chars'(Stream, Cs),
+			'$host_push_answer'(Cs)
+		),
+		(   close(Stream),
+			'$yield_on'
+		)
+	)`
+
+// withPrepared sends the goal of a prepared query to the interpreter as a call to '$prepared_ask'/2,
+// so it's read once as a term instead of being read from a string by '$json_ask'/1.
+// vars are the names of the goal's unbound variables; variables from [WithBind] are added to them.
+func withPrepared(vars []string) QueryOption {
+	return func(q *query) {
+		q.ask = func(goal string) string {
+			var sb strings.Builder
+			sb.WriteString("'$prepared_ask'((")
+			sb.WriteString(goal)
+			sb.WriteString("), [")
+			names := vars
+			for _, bind := range q.bind {
+				if !slices.Contains(names, bind.name) {
+					names = append(slices.Clip(names), bind.name)
+				}
+			}
+			for i, name := range names {
+				if i > 0 {
+					sb.WriteString(", ")
+				}
+				sb.WriteString(Atom(name).String())
+				sb.WriteString(" = ")
+				sb.WriteString(name)
+			}
+			sb.WriteString("]).")
+			return sb.String()
+		}
+	}
+}
+
+// Close removes this query's compiled clause from the interpreter.
+func (pq *PreparedQuery) Close() error {
+	ctx := context.Background()
+	goal := fmt.Sprintf("functor(H, %s, %d), retractall(H).", pq.head.String(), len(pq.vars))
+	return pq.write(ctx, func(pl Prolog) error {
+		_, err := pl.QueryOnce(ctx, goal)
+		return err
+	})
+}
@@ -0,0 +1,197 @@
+package trealla_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/trealla-prolog/go/trealla"
+)
+
+func TestPrepare(t *testing.T) {
+	ctx := context.Background()
+	pl, err := trealla.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pl.Close()
+	pool, err := trealla.NewPool(trealla.WithPoolSize(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	for name, pl := range map[string]trealla.Prolog{"prolog": pl, "pool": pool} {
+		t.Run(name, func(t *testing.T) {
+			pq, err := pl.Prepare(ctx, "member(X, Xs), X > Min")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := pq.Vars(), []string{"X", "Xs", "Min"}; !reflect.DeepEqual(got, want) {
+				t.Error("unexpected vars. want:", want, "got:", got)
+			}
+
+			exec := func(subs trealla.Substitution) []trealla.Term {
+				t.Helper()
+				var xs []trealla.Term
+				q := pq.Exec(ctx, subs)
+				for q.Next(ctx) {
+					xs = append(xs, q.Current().Solution["X"])
+				}
+				if err := q.Err(); err != nil {
+					t.Fatal(err)
+				}
+				return xs
+			}
+			xs := []trealla.Term{int64(1), int64(2), int64(3)}
+			if got, want := exec(trealla.Substitution{"Xs": xs, "Min": int64(1)}), []trealla.Term{int64(2), int64(3)}; !reflect.DeepEqual(got, want) {
+				t.Error("unexpected answers. want:", want, "got:", got)
+			}
+			if got, want := exec(trealla.Substitution{"Xs": xs, "Min": int64(2)}), []trealla.Term{int64(3)}; !reflect.DeepEqual(got, want) {
+				t.Error("unexpected answers. want:", want, "got:", got)
+			}
+
+			if err := pq.Close(); err != nil {
+				t.Fatal(err)
+			}
+			q := pq.Exec(ctx, trealla.Substitution{"Xs": xs, "Min": int64(1)})
+			if q.Next(ctx) {
+				t.Error("closed query returned an answer")
+			}
+			q.Close()
+		})
+	}
+
+	t.Run("restored", func(t *testing.T) {
+		pq, err := pl.Prepare(ctx, "X = 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pq.Close()
+		var snap bytes.Buffer
+		if err := pl.Snapshot(&snap); err != nil {
+			t.Fatal(err)
+		}
+		restored, err := trealla.Restore(&snap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer restored.Close()
+
+		// the restored interpreter already has pq's clause; a new query must not add to it
+		pq2, err := restored.Prepare(ctx, "X = 2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var xs []trealla.Term
+		q := pq2.Exec(ctx, nil)
+		for q.Next(ctx) {
+			xs = append(xs, q.Current().Solution["X"])
+		}
+		if err := q.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if want := []trealla.Term{int64(2)}; !reflect.DeepEqual(xs, want) {
+			t.Error("unexpected answers. want:", want, "got:", xs)
+		}
+	})
+
+	t.Run("options", func(t *testing.T) {
+		pq, err := pl.Prepare(ctx, "atom_length(A, N), write(N)")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pq.Close()
+		q := pq.Exec(ctx, trealla.Substitution{"A": trealla.Atom("it's")}, trealla.WithBind("Extra", "x y"))
+		defer q.Close()
+		if !q.Next(ctx) {
+			t.Fatal(q.Err())
+		}
+		want := trealla.Answer{
+			Query:    q.Current().Query,
+			Solution: trealla.Substitution{"N": int64(4), "Extra": "x y"},
+			Stdout:   "4",
+		}
+		if got := q.Current(); !reflect.DeepEqual(got, want) {
+			t.Error("unexpected answer. want:", want, "got:", got)
+		}
+	})
+
+	t.Run("no variables", func(t *testing.T) {
+		pq, err := pl.Prepare(ctx, "write(hello)")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pq.Close()
+		q := pq.Exec(ctx, nil)
+		defer q.Close()
+		if !q.Next(ctx) {
+			t.Fatal(q.Err())
+		}
+		if got := q.Current(); len(got.Solution) != 0 || got.Stdout != "hello" {
+			t.Error("unexpected answer:", got)
+		}
+	})
+
+	t.Run("exception", func(t *testing.T) {
+		pq, err := pl.Prepare(ctx, "throw(Ball)")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pq.Close()
+		q := pq.Exec(ctx, trealla.Substitution{"Ball": trealla.Atom("oops")})
+		defer q.Close()
+		if q.Next(ctx) {
+			t.Error("unexpected answer:", q.Current())
+		}
+		var ex trealla.ErrThrow
+		if !errors.As(q.Err(), &ex) || ex.Ball != trealla.Atom("oops") {
+			t.Error("unexpected error:", q.Err())
+		}
+	})
+
+	t.Run("syntax error", func(t *testing.T) {
+		_, err := pl.Prepare(ctx, "member(X, ")
+		var ex trealla.ErrThrow
+		if !errors.As(err, &ex) {
+			t.Error("unexpected error:", err)
+		}
+	})
+}
+
+func BenchmarkPrepare(b *testing.B) {
+	ctx := context.Background()
+	pl, err := trealla.New()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer pl.Close()
+	xs := []trealla.Term{int64(1), int64(2), int64(3)}
+
+	b.Run("query", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			q := pl.Query(ctx, "member(X, Xs), X > Min.", trealla.WithBinding(trealla.Substitution{"Xs": xs, "Min": int64(1)}))
+			if !q.Next(ctx) {
+				b.Fatal(q.Err())
+			}
+			q.Close()
+		}
+	})
+
+	b.Run("prepared", func(b *testing.B) {
+		pq, err := pl.Prepare(ctx, "member(X, Xs), X > Min")
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer pq.Close()
+		for n := 0; n < b.N; n++ {
+			q := pq.Exec(ctx, trealla.Substitution{"Xs": xs, "Min": int64(1)})
+			if !q.Next(ctx) {
+				b.Fatal(q.Err())
+			}
+			q.Close()
+		}
+	})
+}
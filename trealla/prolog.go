@@ -15,6 +15,7 @@ import (
 
 	"github.com/tetratelabs/wazero"
 	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/experimental"
 )
 
 const defaultConcurrency = 256
@@ -25,6 +26,16 @@ type Prolog interface {
 	Query(ctx context.Context, query string, options ...QueryOption) Query
 	// QueryOnce executes a query, retrieving a single answer and ignoring others.
 	QueryOnce(ctx context.Context, query string, options ...QueryOption) (Answer, error)
+	// QueryTerm executes a query given as a term instead of text, such as a [Compound] or compound struct.
+	// Variables in goal are named by [Variable.Name] in answers.
+	QueryTerm(ctx context.Context, goal Term, options ...QueryOption) Query
+	// Prepare parses goal once, compiling it into a temporary clause in the interpreter.
+	// The returned query can be executed many times with different bindings, avoiding the cost of parsing each time.
+	// A query prepared with the Prolog passed to a [Pool] transaction is only usable until the transaction ends.
+	Prepare(ctx context.Context, goal string) (*PreparedQuery, error)
+	// Go executes a query, sending its results over the returned channel.
+	// See [Query.Chan].
+	Go(ctx context.Context, query string, options ...QueryOption) <-chan Result
 	// Consult loads a Prolog file with the given path.
 	Consult(ctx context.Context, filename string) error
 	// ConsultText loads Prolog text into module. Use "user" for the global module.
@@ -37,6 +48,9 @@ type Prolog interface {
 	RegisterNondet(ctx context.Context, name string, arity int, predicate NondetPredicate) error
 	// Clone creates a new clone of this interpreter.
 	Clone() (Prolog, error)
+	// Snapshot writes the state of this interpreter to w.
+	// Use [Restore] to create a new interpreter from it.
+	Snapshot(w io.Writer) error
 	// Close destroys the Prolog instance.
 	// If this isn't called and the Prolog variable goes out of scope, runtime finalizers will try to free the memory.
 	Close()
@@ -48,7 +62,10 @@ type prolog struct {
 	ctx      context.Context
 	instance api.Module
 	memory   api.Memory
+	linear   *limitedMemory // nil if unlimited
 	closing  bool
+	trapped  bool   // a wasm call failed, so the interpreter's state can't be trusted
+	txlog    *txLog // records queries during a logged Pool write transaction
 	running  map[uint32]*query
 	spawning map[uint32]*query
 	limiter  chan struct{}
@@ -66,6 +83,8 @@ type prolog struct {
 	pl_query         wasmFunc
 	pl_redo          wasmFunc
 	pl_done          wasmFunc
+	pl_yield_at      wasmFunc
+	pl_did_yield     wasmFunc
 
 	procs map[string]Predicate
 	coros map[int64]coroutine
@@ -77,6 +96,9 @@ type prolog struct {
 	trace   bool
 	quiet   bool
 	max     int
+	memlim  int
+	stop    bool // hard stop, see WithHardStop
+	count   bool // count inferences, see WithInferenceCounter
 
 	stdout *log.Logger
 	stderr *log.Logger
@@ -89,6 +111,11 @@ type prologKey struct{}
 
 // New creates a new Prolog interpreter.
 func New(opts ...Option) (Prolog, error) {
+	pl := newProlog(opts...)
+	return pl, pl.init(nil)
+}
+
+func newProlog(opts ...Option) *prolog {
 	pl := &prolog{
 		running:  make(map[uint32]*query),
 		spawning: make(map[uint32]*query),
@@ -103,7 +130,7 @@ func New(opts ...Option) (Prolog, error) {
 	if pl.max > 0 {
 		pl.limiter = make(chan struct{}, pl.max)
 	}
-	return pl, pl.init(nil)
+	return pl
 }
 
 func (pl *prolog) argv() []string {
@@ -121,7 +148,67 @@ func (pl *prolog) argv() []string {
 }
 
 func (pl *prolog) init(parent *prolog) error {
-	argv := pl.argv()
+	if parent != nil {
+		pl.memlim = parent.memlim
+		pl.stop = parent.stop
+		pl.count = parent.count
+	}
+	if err := pl.instantiate(parent != nil); err != nil {
+		return err
+	}
+
+	if parent != nil {
+		pl.inherit(parent)
+
+		if err := pl.become(parent); err != nil {
+			return err
+		}
+
+		// if any queries are running while we clone, they get copied over as zombies
+		// free them
+		for pp := range parent.spawning {
+			if ptr := pl.indirect(pp); ptr != 0 {
+				if _, err := pl.pl_done.Call(pl.ctx, uint64(ptr)); err != nil {
+					return err
+				}
+			}
+		}
+		for ptr := range parent.running {
+			if _, err := pl.pl_done.Call(pl.ctx, uint64(ptr)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	runtime.SetFinalizer(pl, (*prolog).Close)
+
+	pl_global, err := pl.function("pl_global")
+	if err != nil {
+		return err
+	}
+	ptr, err := pl_global.Call(pl.ctx)
+	if err != nil {
+		return fmt.Errorf("trealla: failed to get interpreter: %w", err)
+	}
+	pl.ptr = uint32(ptr[0])
+
+	_, err = pl.pl_capture.Call(pl.ctx, uint64(pl.ptr))
+	if err != nil {
+		return err
+	}
+
+	if err := pl.loadBuiltins(); err != nil {
+		return fmt.Errorf("trealla: failed to load builtins: %w", err)
+	}
+
+	return nil
+}
+
+// instantiate creates the wasm instance for this interpreter.
+// If resume is true, the interpreter won't be initialized, so its memory can be copied over from another instance.
+func (pl *prolog) instantiate(resume bool) error {
 	fs := wazero.NewFSConfig()
 	for alias, dir := range pl.dirs {
 		fs = fs.WithDirMount(dir, alias)
@@ -130,19 +217,35 @@ func (pl *prolog) init(parent *prolog) error {
 		fs = fs.WithFSMount(fsys, alias)
 	}
 
-	cfg := wazero.NewModuleConfig().WithName("").WithArgs(argv...).WithFSConfig(fs).
+	cfg := wazero.NewModuleConfig().WithName("").WithArgs(pl.argv()...).WithFSConfig(fs).
 		WithSysWalltime().WithSysNanotime().WithSysNanosleep().
 		WithOsyield(runtime.Gosched).
 		// WithStdout(os.Stdout).WithStderr(os.Stderr). // for debugging output capture
 		WithRandSource(rand.Reader)
 
 	// run once to initialize global interpreter
-	if parent != nil {
+	if resume {
 		cfg = cfg.WithStartFunctions()
 	}
 
 	pl.ctx = context.WithValue(context.Background(), prologKey{}, pl)
-	instance, err := wasmEngine.InstantiateModule(pl.ctx, wasmModule, cfg)
+	ctx := pl.ctx
+	if pl.memlim > 0 {
+		if uint64(pl.memlim) < minMemory() {
+			return fmt.Errorf("trealla: memory limit too low: %d bytes (minimum: %d bytes)", pl.memlim, minMemory())
+		}
+		pl.linear = &limitedMemory{max: uint64(pl.memlim)}
+		ctx = experimental.WithMemoryAllocator(ctx, pl.linear)
+	}
+	engine := wasmEngine
+	if pl.stop {
+		engine = stoppableEngine()
+	}
+	module := engine.module
+	if pl.count {
+		module = engine.metered()
+	}
+	instance, err := engine.runtime.InstantiateModule(ctx, module, cfg)
 	if err != nil {
 		return err
 	}
@@ -199,82 +302,26 @@ func (pl *prolog) init(parent *prolog) error {
 		return err
 	}
 
-	// pl.get_error, err = pl.function("get_error")
-	// if err != nil {
-	// 	return err
-	// }
-
-	pl.pl_consult, err = pl.function("pl_consult")
+	pl.pl_yield_at, err = pl.function("pl_yield_at")
 	if err != nil {
 		return err
 	}
 
-	if parent != nil {
-		if pl.ptr == 0 {
-			runtime.SetFinalizer(pl, (*prolog).Close)
-		}
-		pl.ptr = parent.ptr
-		pl.mu = new(sync.Mutex)
-		pl.running = make(map[uint32]*query)
-		pl.spawning = make(map[uint32]*query)
-
-		pl.procs = maps.Clone(parent.procs)
-		pl.coros = make(map[int64]coroutine) // TODO: copy over? probably not
-
-		pl.dirs = parent.dirs
-		pl.fs = parent.fs
-		pl.library = parent.library
-		pl.quiet = parent.quiet
-		pl.trace = parent.trace
-		pl.debug = parent.debug
-		if parent.max > 0 {
-			pl.max = parent.max
-			pl.limiter = make(chan struct{}, pl.max)
-		}
-
-		if err := pl.become(parent); err != nil {
-			return err
-		}
-
-		// if any queries are running while we clone, they get copied over as zombies
-		// free them
-		for pp := range parent.spawning {
-			if ptr := pl.indirect(pp); ptr != 0 {
-				if _, err := pl.pl_done.Call(pl.ctx, uint64(ptr)); err != nil {
-					return err
-				}
-			}
-		}
-		for ptr := range parent.running {
-			if _, err := pl.pl_done.Call(pl.ctx, uint64(ptr)); err != nil {
-				return err
-			}
-		}
-
-		return nil
-	}
-
-	runtime.SetFinalizer(pl, (*prolog).Close)
-
-	pl_global, err := pl.function("pl_global")
+	pl.pl_did_yield, err = pl.function("pl_did_yield")
 	if err != nil {
 		return err
 	}
-	ptr, err := pl_global.Call(pl.ctx)
-	if err != nil {
-		return fmt.Errorf("trealla: failed to get interpreter: %w", err)
-	}
-	pl.ptr = uint32(ptr[0])
 
-	_, err = pl.pl_capture.Call(pl.ctx, uint64(pl.ptr))
+	// pl.get_error, err = pl.function("get_error")
+	// if err != nil {
+	// 	return err
+	// }
+
+	pl.pl_consult, err = pl.function("pl_consult")
 	if err != nil {
 		return err
 	}
 
-	if err := pl.loadBuiltins(); err != nil {
-		return fmt.Errorf("trealla: failed to load builtins: %w", err)
-	}
-
 	return nil
 }
 
@@ -284,6 +331,50 @@ func (pl *prolog) Clone() (Prolog, error) {
 	return pl.clone()
 }
 
+// inherit copies the configuration of parent, for a clone of it.
+func (pl *prolog) inherit(parent *prolog) {
+	if pl.ptr == 0 {
+		runtime.SetFinalizer(pl, (*prolog).Close)
+	}
+	pl.ptr = parent.ptr
+	pl.mu = new(sync.Mutex)
+	pl.running = make(map[uint32]*query)
+	pl.spawning = make(map[uint32]*query)
+
+	pl.procs = maps.Clone(parent.procs)
+	pl.coros = make(map[int64]coroutine) // TODO: copy over? probably not
+
+	pl.dirs = parent.dirs
+	pl.fs = parent.fs
+	pl.library = parent.library
+	pl.quiet = parent.quiet
+	pl.trace = parent.trace
+	pl.debug = parent.debug
+	if parent.max > 0 {
+		pl.max = parent.max
+		pl.limiter = make(chan struct{}, pl.max)
+	}
+}
+
+// revive returns a new interpreter configured like pl, whose memory is restored from mem,
+// a copy of pl's memory from when it was last healthy.
+func (pl *prolog) revive(mem []byte) (*prolog, error) {
+	fresh := &prolog{
+		memlim: pl.memlim,
+		stop:   pl.stop,
+		count:  pl.count,
+	}
+	if err := fresh.instantiate(true); err != nil {
+		return nil, err
+	}
+	fresh.inherit(pl)
+	if err := fresh.load(mem); err != nil {
+		fresh.Close()
+		return nil, err
+	}
+	return fresh, nil
+}
+
 func (pl *prolog) clone() (*prolog, error) {
 	clone := new(prolog)
 	err := clone.init(pl)
@@ -291,19 +382,33 @@ func (pl *prolog) clone() (*prolog, error) {
 }
 
 func (pl *prolog) become(parent *prolog) error {
+	parentBuffer, _ := parent.memory.Read(0, parent.memory.Size())
+	return pl.load(parentBuffer)
+}
+
+// load copies mem into this interpreter's memory, growing it if needed.
+func (pl *prolog) load(mem []byte) error {
 	mySize, _ := pl.memory.Grow(0)
-	parentSize, _ := parent.memory.Grow(0)
-	if parentSize > mySize {
-		if _, ok := pl.memory.Grow(parentSize - mySize); !ok {
-			panic("trealla: failed to become")
+	if size := uint32(len(mem) / pageSize); size > mySize {
+		if _, ok := pl.memory.Grow(size - mySize); !ok {
+			return fmt.Errorf("trealla: failed to grow memory to %d bytes: %w", size*pageSize, ErrMemoryLimit)
 		}
 	}
 	myBuffer, _ := pl.memory.Read(0, pl.memory.Size())
-	parentBuffer, _ := parent.memory.Read(0, parent.memory.Size())
-	copy(myBuffer, parentBuffer)
+	copy(myBuffer, mem)
 	return nil
 }
 
+// outOfMemory reports whether the interpreter tried to exceed its memory limit since the last call.
+func (pl *prolog) outOfMemory() bool {
+	if pl.linear == nil {
+		return false
+	}
+	exceeded := pl.linear.exceeded
+	pl.linear.exceeded = false
+	return exceeded
+}
+
 func (pl *prolog) function(symbol string) (wasmFunc, error) {
 	export := pl.instance.ExportedFunction(symbol)
 	if export == nil {
@@ -387,6 +492,9 @@ func (pl *prolog) Consult(_ context.Context, filename string) error {
 }
 
 func (pl *prolog) consult(filename string) error {
+	if pl.txlog != nil {
+		pl.txlog.add(Atom("consult").Of(Atom(filename)).String())
+	}
 	fstr, err := newCString(pl, filename)
 	if err != nil {
 		return err
@@ -395,6 +503,7 @@ func (pl *prolog) consult(filename string) error {
 
 	ret, err := pl.pl_consult.Call(pl.ctx, uint64(pl.ptr), uint64(fstr.ptr))
 	if err != nil {
+		pl.trapped = true
 		return err
 	}
 	if uint32(ret[0]) == 0 {
@@ -403,6 +512,11 @@ func (pl *prolog) consult(filename string) error {
 	return nil
 }
 
+// healthy reports whether this interpreter is still usable.
+func (pl *prolog) healthy() bool {
+	return pl.instance != nil && !pl.closing && !pl.trapped
+}
+
 func (pl *prolog) indirect(ptr uint32) uint32 {
 	if ptr == 0 {
 		return 0
@@ -445,12 +559,52 @@ func (pl *prolog) stats() Stats {
 type lockedProlog struct {
 	prolog *prolog
 	dead   bool
+	// ctx is the transaction's context, if any
+	ctx context.Context
+	// mu serializes use of the interpreter between the transaction and its channel queries
+	mu sync.Mutex
+
+	// chans tracks goroutines started by Query.Chan, which must stop before the transaction ends
+	chans     sync.WaitGroup
+	chansMu   sync.Mutex
+	chansCtx  context.Context
+	stopChans context.CancelFunc
 }
 
+// kill invalidates this reference, stopping any channel queries and waiting for them to finish.
 func (pl *lockedProlog) kill() {
+	pl.chansMu.Lock()
 	pl.dead = true
+	stop := pl.stopChans
+	pl.chansMu.Unlock()
+	if stop != nil {
+		stop()
+		pl.chans.Wait()
+	}
 	pl.prolog = nil
 }
+
+// queryChan is [queryChan] for queries belonging to this transaction.
+func (pl *lockedProlog) queryChan(ctx context.Context, q Query) <-chan Result {
+	pl.chansMu.Lock()
+	defer pl.chansMu.Unlock()
+	if err := pl.ensure(); err != nil {
+		q.Close()
+		ch := make(chan Result, 1)
+		ch <- Result{Err: err}
+		close(ch)
+		return ch
+	}
+	if pl.chansCtx == nil {
+		pl.chansCtx, pl.stopChans = context.WithCancel(context.Background())
+	}
+	ctx, cancel := mergeContext(ctx, pl.chansCtx)
+	pl.chans.Add(1)
+	return queryChan(ctx, q, func() {
+		cancel()
+		pl.chans.Done()
+	})
+}
 func (pl *lockedProlog) DumpMemory(string) {
 
 }
@@ -466,27 +620,54 @@ func (pl *lockedProlog) Clone() (Prolog, error) {
 	if err := pl.ensure(); err != nil {
 		return nil, err
 	}
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
 	return pl.prolog.clone()
 }
 
+func (pl *lockedProlog) Snapshot(w io.Writer) error {
+	if err := pl.ensure(); err != nil {
+		return err
+	}
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	return pl.prolog.snapshot(w)
+}
+
 func (pl *lockedProlog) Query(ctx context.Context, ask string, options ...QueryOption) Query {
 	if err := pl.ensure(); err != nil {
 		return &query{err: err}
 	}
-	return pl.prolog.Query(ctx, ask, append(options, withoutLock)...)
+	return pl.prolog.Query(ctx, ask, append(options, withoutLock, withTx(pl))...)
+}
+
+func (pl *lockedProlog) QueryTerm(ctx context.Context, goal Term, options ...QueryOption) Query {
+	text, err := marshalGoal(goal)
+	if err != nil {
+		return failedQuery(err)
+	}
+	return pl.Query(ctx, text, options...)
+}
+
+func (pl *lockedProlog) Go(ctx context.Context, query string, options ...QueryOption) <-chan Result {
+	return pl.Query(ctx, query, options...).Chan(ctx)
 }
 
 func (pl *lockedProlog) QueryOnce(ctx context.Context, query string, options ...QueryOption) (Answer, error) {
 	if err := pl.ensure(); err != nil {
 		return Answer{}, err
 	}
-	return pl.prolog.queryOnce(ctx, query, options...)
+	return pl.prolog.queryOnce(ctx, query, append(options, withTx(pl))...)
 }
 
 func (pl *lockedProlog) ConsultText(ctx context.Context, module, text string) error {
 	if err := pl.ensure(); err != nil {
 		return err
 	}
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	ctx, cancel := mergeContext(ctx, pl.ctx)
+	defer cancel()
 	return pl.prolog.consultText(ctx, module, text)
 }
 
@@ -494,6 +675,8 @@ func (pl *lockedProlog) Consult(_ context.Context, filename string) error {
 	if err := pl.ensure(); err != nil {
 		return err
 	}
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
 	return pl.prolog.consult(filename)
 }
 
@@ -501,6 +684,10 @@ func (pl *lockedProlog) Register(ctx context.Context, name string, arity int, pr
 	if err := pl.ensure(); err != nil {
 		return err
 	}
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	ctx, cancel := mergeContext(ctx, pl.ctx)
+	defer cancel()
 	return pl.prolog.register(ctx, name, arity, proc)
 }
 
@@ -508,6 +695,10 @@ func (pl *lockedProlog) RegisterNondet(ctx context.Context, name string, arity i
 	if err := pl.ensure(); err != nil {
 		return err
 	}
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	ctx, cancel := mergeContext(ctx, pl.ctx)
+	defer cancel()
 	return pl.prolog.registerNondet(ctx, name, arity, proc)
 }
 
@@ -515,6 +706,8 @@ func (pl *lockedProlog) Close() {
 	if err := pl.ensure(); err != nil {
 		return
 	}
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
 	pl.prolog.closing = true
 }
 
@@ -522,6 +715,8 @@ func (pl *lockedProlog) Stats() Stats {
 	if err := pl.ensure(); err != nil {
 		return Stats{}
 	}
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
 	return pl.prolog.stats()
 }
 
@@ -607,7 +802,7 @@ func WithDebugLog(logger *log.Logger) Option {
 // This is useful for limiting the amount of memory an interpreter will use.
 // Set to 0 to disable concurrency limits. Default is 256.
 // Note that interpreters are single-threaded, so only one query is truly executing
-// at once, but pending queries can still consume memory (which is currently limited to 4GB).
+// at once, but pending queries can still consume memory (up to 4GB, or the limit set by [WithMemoryLimit]).
 // This knob will limit the number of queries that can actively consume the interpreter's memory.
 func WithMaxConcurrency(queries int) Option {
 	return func(pl *prolog) {
@@ -615,7 +810,44 @@ func WithMaxConcurrency(queries int) Option {
 	}
 }
 
+// WithMemoryLimit sets the maximum size of the interpreter's memory in bytes.
+// Queries that need more memory than this will throw resource_error(memory)
+// or return an error wrapping [ErrMemoryLimit].
+// Clones inherit the limit of their parent.
+// Set to 0 to disable the limit. The default is no limit (which is currently 4GB).
+func WithMemoryLimit(bytes int) Option {
+	return func(pl *prolog) {
+		pl.memlim = bytes
+	}
+}
+
+// WithHardStop lets canceled queries be stopped even if they never yield, such as a deterministic loop.
+// Normally queries only check their context when they backtrack.
+// With this option, a query that is still running a second after its context is done has its interpreter closed,
+// after which the interpreter is unusable. A [Pool] replaces such interpreters automatically.
+// Clones inherit this option from their parent.
+// This makes all queries noticeably slower, so only use it when you need to stop untrusted goals.
+func WithHardStop() Option {
+	return func(pl *prolog) {
+		pl.stop = true
+	}
+}
+
+// WithInferenceCounter counts the inferences made by queries, letting them be limited with [WithMaxInferences].
+// An inference here is a function call inside the interpreter, which is finer-grained than a Prolog
+// logical inference. Unlike a timeout, the count doesn't depend on the machine or its load:
+// the same query against the same program makes the same number of them, give or take a few
+// for the interpreter's internal state (its first query does some extra setup, for example).
+// Clones inherit this option from their parent.
+// This makes all queries several times slower, so only use it when you need to limit them.
+func WithInferenceCounter() Option {
+	return func(pl *prolog) {
+		pl.count = true
+	}
+}
+
 var (
 	_ Prolog = (*prolog)(nil)
 	_ Prolog = (*lockedProlog)(nil)
+	_ Prolog = (*Pool)(nil)
 )
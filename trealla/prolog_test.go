@@ -2,6 +2,7 @@ package trealla
 
 import (
 	"context"
+	"errors"
 	"io"
 	"reflect"
 	"testing"
@@ -127,3 +128,69 @@ func TestLeakCheck(t *testing.T) {
 	t.Run("simple interop", check("interop_simple(X)", 0))
 	// t.Run("complex interop", check("interop_test(X)"))
 }
+
+func TestMemoryLimit(t *testing.T) {
+	const limit = 32 * 1024 * 1024
+	ctx := context.Background()
+
+	pl, err := New(WithMemoryLimit(limit))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pl.Close()
+
+	check := func(t *testing.T, pl Prolog) {
+		t.Helper()
+		_, err := pl.QueryOnce(ctx, "findall(X, between(1, 10000000, X), Xs).")
+		var ex ErrThrow
+		switch {
+		case errors.As(err, &ex):
+			want := Atom("resource_error").Of(Atom("memory"))
+			if got := ex.Ball.(Compound).Args[0]; !reflect.DeepEqual(want, got) {
+				t.Error("unexpected error. want:", want, "got:", got)
+			}
+		case errors.Is(err, ErrMemoryLimit):
+		default:
+			t.Error("unexpected error:", err)
+		}
+		if size := pl.Stats().MemorySize; size > limit {
+			t.Error("memory grew past limit:", size)
+		}
+		if _, err := pl.QueryOnce(ctx, "X = 1."); err != nil {
+			t.Error("interpreter unusable after hitting limit:", err)
+		}
+	}
+
+	t.Run("new", func(t *testing.T) {
+		check(t, pl)
+	})
+
+	t.Run("clone", func(t *testing.T) {
+		clone, err := pl.Clone()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer clone.Close()
+		check(t, clone)
+	})
+
+	t.Run("pool", func(t *testing.T) {
+		pool, err := NewPool(WithPoolSize(2), WithPoolPrologOption(WithMemoryLimit(limit)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = pool.ReadTx(func(pl Prolog) error {
+			check(t, pl)
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("too low", func(t *testing.T) {
+		if _, err := New(WithMemoryLimit(pageSize)); err == nil {
+			t.Error("expected error for tiny memory limit")
+		}
+	})
+}
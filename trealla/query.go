@@ -3,28 +3,44 @@ package trealla
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"iter"
 	"runtime"
 	"strings"
 	"sync"
+	"time"
 )
 
 const stx = '\x02' // START OF TEXT
 const etx = '\x03' // END OF TEXT
 
+// yieldInterval is how often cancelable queries check their context.
+const yieldInterval = 10 * time.Millisecond
+
+// hardStopGrace is how long a canceled query has to yield before its interpreter is forcibly stopped.
+const hardStopGrace = time.Second
+
 type queryContext struct{}
 
 // Query is a Prolog query iterator.
 type Query interface {
 	// Next computes the next solution. Returns true if it found one and false if there are no more results.
 	// Be sure to check for errors by calling Err afterwards.
+	// Canceling ctx aborts the query, and Err will return an error wrapping ctx.Err().
+	// Queries only check ctx when they backtrack, so a query that doesn't (such as a deterministic loop)
+	// keeps running until it finishes. Use [WithHardStop] to forcibly stop such queries.
 	Next(context.Context) bool
 	// All returns an iterator over query results.
 	// Be sure to check for errors by calling Err afterwards.
 	// This is a single-use iterator.
 	All(context.Context) iter.Seq[Answer]
+	// Chan computes results on a separate goroutine and sends them over the returned channel.
+	// At most one answer is computed ahead of the one waiting to be received.
+	// If the query fails or throws, the last Result holds the error.
+	// The query is closed and the channel is closed when the results are exhausted or ctx is done.
+	Chan(context.Context) <-chan Result
 	// Current returns the current solution prepared by Next.
 	Current() Answer
 	// Close destroys this query. It is not necessary to call this if you exhaust results via Next.
@@ -34,6 +50,41 @@ type Query interface {
 	Err() error
 }
 
+// StatsQuery is a [Query] that reports diagnostic information.
+// Queries created by this package implement it:
+//
+//	if sq, ok := q.(trealla.StatsQuery); ok {
+//		log.Println(sq.Stats())
+//	}
+type StatsQuery interface {
+	Query
+	// Stats returns diagnostic information about this query.
+	Stats() QueryStats
+}
+
+// Result is an answer or an error sent by [Query.Chan].
+type Result struct {
+	Answer Answer
+	Err    error
+}
+
+// QueryStats is diagnostic information about a query.
+type QueryStats struct {
+	// WallTime is the time elapsed between starting and finishing the query,
+	// or the time elapsed so far for unfinished queries.
+	WallTime time.Duration
+	// ExecTime is the time spent executing inside of the interpreter,
+	// including calls to native Go predicates.
+	ExecTime time.Duration
+	// HostCalls is the number of calls made to native Go predicates.
+	HostCalls int
+	// MemoryGrowth is how much the interpreter's memory grew while running the query, in bytes.
+	MemoryGrowth int
+	// Inferences is the number of inferences the query made, as limited by [WithMaxInferences].
+	// It is only counted by interpreters created with [WithInferenceCounter], and is zero otherwise.
+	Inferences int64
+}
+
 type query struct {
 	pl       *prolog
 	goal     string
@@ -50,6 +101,18 @@ type query struct {
 	dead    bool
 	iter    int
 
+	// stats
+	started   time.Time
+	finished  time.Time
+	exectime  time.Duration
+	hostcalls int
+	memstart  uint32 // pages
+	mempeak   uint32 // pages
+
+	// inferences counted by an interpreter created with WithInferenceCounter
+	inferences    int64
+	maxInferences int64
+
 	// output capture pointers
 	stdoutptr uint32 // char**
 	stdoutlen uint32 // size_t*
@@ -61,6 +124,16 @@ type query struct {
 
 	lock bool
 	mu   *sync.Mutex
+
+	// enclosing Pool transaction, if any
+	tx *lockedProlog
+	// context of the enclosing Pool transaction, if any
+	txctx context.Context
+	// write log recording this query, if any, and the query's number in it
+	log   *txLog
+	logno int
+	// ask overrides the query text given to the interpreter, which wraps goal in '$json_ask'/1 by default
+	ask func(goal string) string
 }
 
 // Query executes a query, returning an iterator for results.
@@ -70,6 +143,18 @@ func (pl *prolog) Query(ctx context.Context, goal string, options ...QueryOption
 	return q
 }
 
+func (pl *prolog) QueryTerm(ctx context.Context, goal Term, options ...QueryOption) Query {
+	text, err := marshalGoal(goal)
+	if err != nil {
+		return failedQuery(err)
+	}
+	return pl.Query(ctx, text, options...)
+}
+
+func (pl *prolog) Go(ctx context.Context, goal string, options ...QueryOption) <-chan Result {
+	return pl.Query(ctx, goal, options...).Chan(ctx)
+}
+
 func (pl *prolog) QueryOnce(ctx context.Context, goal string, options ...QueryOption) (Answer, error) {
 	pl.mu.Lock()
 	defer pl.mu.Unlock()
@@ -87,6 +172,15 @@ func (pl *prolog) queryOnce(ctx context.Context, goal string, options ...QueryOp
 	return ans, q.Err()
 }
 
+// failedQuery returns a query that failed to start.
+func failedQuery(err error) *query {
+	return &query{
+		err:  err,
+		dead: true,
+		mu:   new(sync.Mutex),
+	}
+}
+
 func (q *query) allocCapture() error {
 	pl := q.pl
 	var err error
@@ -160,24 +254,42 @@ func (q *query) resetOutput() {
 
 func (pl *prolog) start(ctx context.Context, goal string, options ...QueryOption) *query {
 	q := &query{
-		pl:     pl,
-		goal:   goal,
-		lock:   true,
-		stdout: new(bytes.Buffer),
-		stderr: new(bytes.Buffer),
-		mu:     new(sync.Mutex),
+		pl:      pl,
+		goal:    goal,
+		lock:    true,
+		stdout:  new(bytes.Buffer),
+		stderr:  new(bytes.Buffer),
+		mu:      new(sync.Mutex),
+		started: time.Now(),
 	}
 	for _, opt := range options {
 		opt(q)
 	}
+	ctx, cancel := mergeContext(ctx, q.txctx)
+	defer cancel()
+
+	if err := ctx.Err(); err != nil {
+		q.dead = true
+		q.setError(canceled(ctx))
+		return q
+	}
 
 	if pl.limiter != nil {
-		pl.limiter <- struct{}{}
+		select {
+		case pl.limiter <- struct{}{}:
+		case <-ctx.Done():
+			q.dead = true
+			q.setError(canceled(ctx))
+			return q
+		}
 	}
 
 	if q.lock {
 		pl.mu.Lock()
 		defer pl.mu.Unlock()
+	} else if q.tx != nil {
+		q.tx.mu.Lock()
+		defer q.tx.mu.Unlock()
 	}
 	if q.pl.instance == nil || pl.closing {
 		q.setError(io.EOF)
@@ -190,7 +302,15 @@ func (pl *prolog) start(ctx context.Context, goal string, options ...QueryOption
 		q.setError(err)
 		return q
 	}
-	goalstr, err := newCString(pl, escapeQuery(q.goal))
+	if pl.txlog != nil {
+		q.log = pl.txlog
+		q.logno = pl.txlog.add(q.goal)
+	}
+	ask := escapeQuery(q.goal)
+	if q.ask != nil {
+		ask = q.ask(q.goal)
+	}
+	goalstr, err := newCString(pl, ask)
 	if err != nil {
 		q.setError(err)
 		return q
@@ -216,38 +336,19 @@ func (pl *prolog) start(ctx context.Context, goal string, options ...QueryOption
 		return q
 	}
 
-	// ch := make(chan error, 2)
+	pl.outOfMemory() // reset
+	q.memstart, _ = pl.memory.Grow(0)
+	q.mempeak = q.memstart
 	var ret uint32
-	v, err := pl.pl_query.Call(ctx, uint64(pl.ptr), uint64(goalstr.ptr), uint64(subqptr), 0)
+	v, err := q.exec(ctx, pl.pl_query, uint64(pl.ptr), uint64(goalstr.ptr), uint64(subqptr), uint64(yieldTime(ctx)))
 	if err == nil {
 		ret = uint32(v[0])
 	}
 	goalstr.free(pl)
-	// go func() {
-	// 	defer func() {
-	// 		if ex := recover(); ex != nil {
-	// 			ch <- fmt.Errorf("trealla: panic: %v", ex)
-	// 		}
-	// 	}()
-
-	// 	v, err := pl.pl_query.Call(pl.ctx, uint64(pl.ptr), uint64(goalstr.ptr), uint64(subqptr), 0)
-	// 	if err == nil {
-	// 		ret = uint32(v[0])
-	// 	}
-	// 	goalstr.free(pl)
-	// 	ch <- err
-	// }()
-
-	// select {
-	// case <-ctx.Done():
-	// 	q.setError(fmt.Errorf("trealla: canceled: %w", ctx.Err()))
-	// 	return q
-
-	// case err := <-ch:
 	q.done = ret == 0
 
 	if err != nil {
-		q.setError(fmt.Errorf("trealla: query error: %w", err))
+		q.setError(pl.queryError(err))
 		return q
 	}
 
@@ -256,18 +357,29 @@ func (pl *prolog) start(ctx context.Context, goal string, options ...QueryOption
 		var err error
 		q.subquery = pl.indirect(subqptr)
 		if q.subquery == 0 {
+			// pl_query claimed success but didn't give us a subquery, so something is badly wrong
+			pl.trapped = true
 			q.setError(fmt.Errorf("trealla: couldn't read subquery pointer: %w", err))
 			return q
 		}
 		q.pl.running[q.subquery] = q
+
+		if err := q.resume(ctx); err != nil {
+			q.setError(err)
+			q.close()
+			return q
+		}
+		if q.done {
+			delete(pl.running, q.subquery)
+		}
 	}
+	q.checkMemory()
 
 	if pl.closing {
 		pl.Close()
 	}
 
 	return q
-	// }
 }
 
 func (q *query) redo(ctx context.Context) bool {
@@ -279,6 +391,11 @@ func (q *query) redo(ctx context.Context) bool {
 		q.setError(io.EOF)
 		return false
 	}
+	if err := ctx.Err(); err != nil {
+		q.setError(canceled(ctx))
+		q.close()
+		return false
+	}
 
 	if q.pl.debug != nil {
 		q.pl.debug.Println("redo:", q.subquery, q.goal)
@@ -287,58 +404,39 @@ func (q *query) redo(ctx context.Context) bool {
 	pl := q.pl
 	ctx = context.WithValue(ctx, queryContext{}, q)
 
-	// ch := make(chan error, 2)
 	var ret uint32
-	// go func() {
-	// 	defer func() {
-	// 		if ex := recover(); ex != nil {
-	// 			ch <- fmt.Errorf("trealla: panic: %v", ex)
-	// 		}
-	// 	}()
-
-	// 	v, err := pl.pl_redo.Call(pl.ctx, uint64(q.subquery))
-	// 	if err == nil {
-	// 		ret = uint32(v[0])
-	// 	}
-	// 	ch <- err
-	// }()
-
-	v, err := pl.pl_redo.Call(ctx, uint64(q.subquery))
+	if _, err := pl.pl_yield_at.Call(context.WithoutCancel(ctx), uint64(q.subquery), uint64(yieldTime(ctx))); err != nil {
+		q.setError(fmt.Errorf("trealla: query error: %w", err))
+		q.close()
+		return false
+	}
+	pl.outOfMemory() // reset
+	v, err := q.exec(ctx, pl.pl_redo, uint64(q.subquery))
 	q.iter++
 	if err == nil {
 		ret = uint32(v[0])
 	}
 
-	// select {
-	// case <-ctx.Done():
-	// 	q.setError(fmt.Errorf("trealla: canceled: %w", ctx.Err()))
-	// 	q.Close()
-	// 	return false
-
-	// case err := <-ch:
 	q.done = ret == 0
 	if err != nil {
-		q.setError(fmt.Errorf("trealla: query error: %w", err))
+		q.setError(pl.queryError(err))
 		q.close()
 		return false
 	}
 
-	// var erroring bool
-	// var errcode uint64
-	// {
-	// 	retv, err2 := pl.get_error.Call(ctx, uint64(pl.ptr))
-	// 	if err2 != nil {
-	// 		q.setError(fmt.Errorf("trealla: get_error internal error: %w", err))
-	// 		return false
-	// 	}
-	// 	errcode = retv[0]
-	// 	erroring = errcode != 0
-	// }
+	if !q.done {
+		if err := q.resume(ctx); err != nil {
+			q.setError(err)
+			q.close()
+			return false
+		}
+	}
 
 	if q.done {
 		delete(pl.running, q.subquery)
 		defer q.close()
 	}
+	q.checkMemory()
 
 	if pl.closing {
 		pl.Close()
@@ -350,9 +448,49 @@ func (q *query) redo(ctx context.Context) bool {
 	return true
 }
 
+// resume continues running a query that yielded control back to us, until it
+// finds an answer or finishes. Queries only yield when their context can be canceled,
+// giving us a chance to stop them cleanly.
+func (q *query) resume(ctx context.Context) error {
+	pl := q.pl
+	for !q.done {
+		v, err := pl.pl_did_yield.Call(context.WithoutCancel(ctx), uint64(q.subquery))
+		if err != nil {
+			return fmt.Errorf("trealla: query error: %w", err)
+		}
+		if uint32(v[0]) == 0 {
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return canceled(ctx)
+		}
+
+		if _, err := pl.pl_yield_at.Call(context.WithoutCancel(ctx), uint64(q.subquery), uint64(yieldTime(ctx))); err != nil {
+			return fmt.Errorf("trealla: query error: %w", err)
+		}
+		v, err = q.exec(ctx, pl.pl_redo, uint64(q.subquery))
+		if err != nil {
+			q.done = true
+			return pl.queryError(err)
+		}
+		q.done = uint32(v[0]) == 0
+	}
+	return nil
+}
+
 func (q *query) Next(ctx context.Context) bool {
 	q.mu.Lock()
 	defer q.mu.Unlock()
+	// hold the transaction's lock for all of Next, so its log records calls in the order they run
+	if q.tx != nil {
+		q.tx.mu.Lock()
+		defer q.tx.mu.Unlock()
+	}
+
+	if q.log != nil {
+		q.log.next(q.logno)
+	}
 
 	if q.err != nil {
 		return false
@@ -366,6 +504,8 @@ func (q *query) Next(ctx context.Context) bool {
 		return false
 	}
 
+	ctx, cancel := mergeContext(ctx, q.txctx)
+	defer cancel()
 	if q.redo(ctx) {
 		got := q.pop()
 		return got
@@ -404,6 +544,40 @@ func (q *query) All(ctx context.Context) iter.Seq[Answer] {
 	}
 }
 
+func (q *query) Chan(ctx context.Context) <-chan Result {
+	if q.tx != nil {
+		return q.tx.queryChan(ctx, q)
+	}
+	return queryChan(ctx, q, nil)
+}
+
+// queryChan sends the results of q over the returned channel from a new goroutine.
+// If done is not nil, it is called when the goroutine exits.
+func queryChan(ctx context.Context, q Query, done func()) <-chan Result {
+	ch := make(chan Result)
+	go func() {
+		if done != nil {
+			defer done()
+		}
+		defer close(ch)
+		defer q.Close()
+		for q.Next(ctx) {
+			select {
+			case ch <- Result{Answer: q.Current()}:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err := q.Err(); err != nil {
+			select {
+			case ch <- Result{Err: err}:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return ch
+}
+
 func (q *query) Current() Answer {
 	q.mu.Lock()
 	defer q.mu.Unlock()
@@ -417,12 +591,16 @@ func (q *query) Close() error {
 	if q.lock {
 		q.pl.mu.Lock()
 		defer q.pl.mu.Unlock()
+	} else if q.tx != nil {
+		q.tx.mu.Lock()
+		defer q.tx.mu.Unlock()
 	}
 
 	return q.close()
 }
 
 func (q *query) close() error {
+	q.finish()
 	if !q.dead {
 		q.dead = true
 		if q.pl.limiter != nil {
@@ -491,8 +669,16 @@ func (q *query) reify() error {
 	}
 
 	var sb strings.Builder
-	sb.WriteString(q.bind.String())
-	sb.WriteString(", ")
+	for _, bind := range q.bind {
+		value, err := marshal(bind.value)
+		if err != nil {
+			return err
+		}
+		sb.WriteString(bind.name)
+		sb.WriteString(" = ")
+		sb.WriteString(value)
+		sb.WriteString(", ")
+	}
 	sb.WriteString(q.goal)
 	q.goal = sb.String()
 	return nil
@@ -510,6 +696,116 @@ func (q *query) Err() error {
 	return q.err
 }
 
+// exec calls pl_query or pl_redo, keeping track of stats.
+func (q *query) exec(ctx context.Context, fn wasmFunc, params ...uint64) ([]uint64, error) {
+	hard := ctx
+	if q.pl.stop {
+		var stop context.CancelFunc
+		hard, stop = hardContext(ctx)
+		defer stop()
+	}
+	start := time.Now()
+	v, err := fn.Call(hard, params...)
+	q.exectime += time.Since(start)
+	if err != nil && hard.Err() != nil {
+		err = fmt.Errorf("interpreter was stopped: %w", canceled(ctx))
+	}
+	if errors.Is(err, errInferenceLimit) {
+		err = ErrThrow{
+			Query: q.goal,
+			Ball:  Atom("error").Of(Atom("resource_error").Of(Atom("inferences")), q.maxInferences),
+		}
+	}
+	if size, ok := q.pl.memory.Grow(0); ok {
+		q.mempeak = max(q.mempeak, size)
+	}
+	if err != nil {
+		q.pl.trapped = true
+	}
+	if err != nil || uint32(v[0]) == 0 {
+		q.finish()
+	}
+	return v, err
+}
+
+func (q *query) finish() {
+	if q.finished.IsZero() {
+		q.finished = time.Now()
+	}
+}
+
+func (q *query) Stats() QueryStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	end := q.finished
+	if end.IsZero() {
+		end = time.Now()
+	}
+	return QueryStats{
+		WallTime:     end.Sub(q.started),
+		ExecTime:     q.exectime,
+		HostCalls:    q.hostcalls,
+		MemoryGrowth: int(q.mempeak-q.memstart) * pageSize,
+		Inferences:   q.inferences,
+	}
+}
+
+// checkMemory reports an error for queries that gave up without an answer
+// because they ran into the interpreter's memory limit.
+func (q *query) checkMemory() {
+	if q.pl.outOfMemory() && q.err == nil && len(q.answers) == 0 {
+		q.setError(fmt.Errorf("trealla: query error: %w", ErrMemoryLimit))
+	}
+}
+
+// queryError wraps an error returned by the interpreter.
+func (pl *prolog) queryError(err error) error {
+	if pl.outOfMemory() {
+		return fmt.Errorf("trealla: query error: %w: %w", ErrMemoryLimit, err)
+	}
+	return fmt.Errorf("trealla: query error: %w", err)
+}
+
+// yieldTime returns the number of milliseconds a query may run before
+// yielding back to Go to check for cancelation. Zero disables yielding.
+func yieldTime(ctx context.Context) uint32 {
+	if ctx.Done() == nil {
+		return 0
+	}
+	interval := yieldInterval
+	if deadline, ok := ctx.Deadline(); ok {
+		interval = min(interval, time.Until(deadline))
+	}
+	return uint32(max(interval, time.Millisecond) / time.Millisecond)
+}
+
+// hardContext returns a context for running the interpreter that is canceled once ctx
+// has been done for hardStopGrace. Canceling it closes interpreters created with [WithHardStop],
+// which is the only way to stop a query that never yields. Queries that yield are stopped cleanly before then.
+func hardContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ctx.Done() == nil {
+		return ctx, func() {}
+	}
+	hard, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		timer := time.NewTimer(hardStopGrace)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			cancel()
+		case <-hard.Done():
+		}
+	})
+	return hard, func() {
+		stop()
+		cancel()
+	}
+}
+
+func canceled(ctx context.Context) error {
+	return fmt.Errorf("trealla: canceled: %w", context.Cause(ctx))
+}
+
 func escapeQuery(query string) string {
 	query = queryEscaper.Replace(query)
 	return fmt.Sprintf(`'$json_ask'(%s).`, escapeString(query))
@@ -537,10 +833,49 @@ func WithBinding(subs Substitution) QueryOption {
 	}
 }
 
+// WithMaxInferences limits the number of inferences a query can make, counting from when it starts.
+// A query that exceeds the limit throws error(resource_error(inferences), Limit).
+// The interpreter must be created with [WithInferenceCounter], otherwise the limit is ignored.
+// Exceeding the limit stops the interpreter in the middle of its work, which breaks it for good.
+// A [Pool] replaces broken interpreters automatically.
+func WithMaxInferences(limit int64) QueryOption {
+	return func(q *query) {
+		q.maxInferences = limit
+	}
+}
+
 func withoutLock(q *query) {
 	q.lock = false
 }
 
+// withTx makes the query belong to a transaction, respecting its context
+// and stopping its [Query.Chan] goroutine when the transaction ends.
+func withTx(tx *lockedProlog) QueryOption {
+	return func(q *query) {
+		q.tx = tx
+		q.txctx = tx.ctx
+	}
+}
+
+// mergeContext returns a context that is canceled when either ctx or other is done.
+// other may be nil.
+func mergeContext(ctx, other context.Context) (context.Context, context.CancelFunc) {
+	if other == nil || other.Done() == nil {
+		return ctx, func() {}
+	}
+	merged, cancel := context.WithCancelCause(ctx)
+	stop := context.AfterFunc(other, func() {
+		cancel(context.Cause(other))
+	})
+	return merged, func() {
+		stop()
+		cancel(nil)
+	}
+}
+
 var queryEscaper = strings.NewReplacer("\t", " ", "\n", " ", "\r", "")
 
-var _ Query = (*query)(nil)
+var (
+	_ StatsQuery = (*query)(nil)
+	_ StatsQuery = (*poolQuery)(nil)
+)
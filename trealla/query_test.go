@@ -13,6 +13,7 @@ import (
 	"sync"
 	"testing"
 	"testing/fstest"
+	"time"
 
 	"github.com/trealla-prolog/go/trealla"
 )
@@ -587,3 +588,353 @@ func TestConcurrencyDet100K(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestCancel(t *testing.T) {
+	t.Parallel()
+
+	pl, err := trealla.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pl.Close()
+
+	t.Run("deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := pl.QueryOnce(ctx, "repeat, fail.")
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Error("unexpected error. want: deadline exceeded, got:", err)
+		}
+		if took := time.Since(start); took > time.Second {
+			t.Error("query took too long to cancel:", took)
+		}
+	})
+
+	t.Run("cancel during Next", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		q := pl.Query(ctx, "between(1, 3, X), (X = 3 -> repeat, fail ; true).")
+		if !q.Next(ctx) {
+			t.Fatal("expected an answer, got error:", q.Err())
+		}
+		time.AfterFunc(50*time.Millisecond, cancel)
+		for q.Next(ctx) {
+		}
+		if !errors.Is(q.Err(), context.Canceled) {
+			t.Error("unexpected error. want: canceled, got:", q.Err())
+		}
+	})
+
+	t.Run("already canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := pl.QueryOnce(ctx, "true.")
+		if !errors.Is(err, context.Canceled) {
+			t.Error("unexpected error. want: canceled, got:", err)
+		}
+	})
+
+	t.Run("still usable", func(t *testing.T) {
+		ans, err := pl.QueryOnce(context.Background(), "X = 1.")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if x := ans.Solution["X"]; x != int64(1) {
+			t.Error("unexpected answer:", x)
+		}
+	})
+
+	t.Run("pool", func(t *testing.T) {
+		pool, err := trealla.NewPool(trealla.WithPoolSize(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		err = pool.ReadTx(func(pl trealla.Prolog) error {
+			_, err := pl.QueryOnce(ctx, "repeat, fail.")
+			return err
+		})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Error("unexpected error. want: deadline exceeded, got:", err)
+		}
+		err = pool.ReadTx(func(pl trealla.Prolog) error {
+			_, err := pl.QueryOnce(context.Background(), "true.")
+			return err
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	// queries that never backtrack don't yield, so they are stopped by force
+	loops := []string{
+		"l2.",
+		"loop(0).",
+		"length(L, N), N > 100000000.",
+	}
+	for _, goal := range loops {
+		t.Run("hard stop "+goal, func(t *testing.T) {
+			pool, err := trealla.NewPool(trealla.WithPoolSize(1), trealla.WithPoolPrologOption(trealla.WithHardStop()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer pool.Close()
+			if err := pool.ConsultText(context.Background(), "user", "l2 :- l2. loop(X) :- Y is X+1, loop(Y)."); err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			_, err = pool.QueryOnce(ctx, goal)
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Error("unexpected error. want: deadline exceeded, got:", err)
+			}
+			if took := time.Since(start); took > 5*time.Second {
+				t.Error("query took too long to stop:", took)
+			}
+			// the broken replica was replaced
+			if _, err := pool.QueryOnce(context.Background(), "true."); err != nil {
+				t.Error("pool unusable after hard stop:", err)
+			}
+			if got := pool.PoolStats().Replaced; got != 1 {
+				t.Error("unexpected replaced count. want: 1 got:", got)
+			}
+		})
+	}
+}
+
+func TestMaxInferences(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	pool, err := trealla.NewPool(trealla.WithPoolSize(1), trealla.WithPoolPrologOption(trealla.WithInferenceCounter()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	if err := pool.ConsultText(ctx, "user", "l2 :- l2."); err != nil {
+		t.Fatal(err)
+	}
+
+	q := pool.Query(ctx, "length(L, 100).", trealla.WithMaxInferences(10_000_000))
+	if !q.Next(ctx) {
+		t.Error("query within the limit failed:", q.Err())
+	}
+	if n := q.(trealla.StatsQuery).Stats().Inferences; n <= 0 || n > 10_000_000 {
+		t.Error("unexpected inference count:", n)
+	}
+	q.Close()
+
+	// the same query fails the same way every time
+	for i := 0; i < 2; i++ {
+		_, err := pool.QueryOnce(ctx, "l2.", trealla.WithMaxInferences(1_000_000))
+		var ex trealla.ErrThrow
+		if !errors.As(err, &ex) {
+			t.Fatal("unexpected error. want: ErrThrow, got:", err)
+		}
+		want := trealla.Atom("error").Of(trealla.Atom("resource_error").Of(trealla.Atom("inferences")), int64(1_000_000))
+		if !reflect.DeepEqual(ex.Ball, want) {
+			t.Errorf("unexpected ball. want: %v got: %v", want, ex.Ball)
+		}
+	}
+	// the broken replicas were replaced
+	if _, err := pool.QueryOnce(ctx, "true."); err != nil {
+		t.Error("pool unusable after exceeding the limit:", err)
+	}
+	if got := pool.PoolStats().Replaced; got != 2 {
+		t.Error("unexpected replaced count. want: 2 got:", got)
+	}
+
+	t.Run("without counter", func(t *testing.T) {
+		pl, err := trealla.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pl.Close()
+		if _, err := pl.QueryOnce(ctx, "length(L, 100).", trealla.WithMaxInferences(1)); err != nil {
+			t.Error("limit wasn't ignored:", err)
+		}
+	})
+}
+
+func TestQueryStats(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	pl, err := trealla.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pl.Close()
+
+	err = pl.Register(ctx, "go_id", 2, func(_ trealla.Prolog, _ trealla.Subquery, goal trealla.Term) trealla.Term {
+		g := goal.(trealla.Compound)
+		return trealla.Atom("go_id").Of(g.Args[0], g.Args[0])
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := pl.Query(ctx, `between(1, 3, X), go_id(X, Y), \+ \+ numlist(1, 100000, _).`)
+	for q.Next(ctx) {
+	}
+	if err := q.Err(); err != nil {
+		t.Fatal(err)
+	}
+	sq, ok := q.(trealla.StatsQuery)
+	if !ok {
+		t.Fatal("query doesn't report stats")
+	}
+	stats := sq.Stats()
+	if stats.HostCalls != 3 {
+		t.Error("unexpected host calls. want: 3 got:", stats.HostCalls)
+	}
+	if stats.ExecTime <= 0 || stats.WallTime < stats.ExecTime {
+		t.Error("unexpected timing:", stats.ExecTime, stats.WallTime)
+	}
+	if stats.MemoryGrowth < 0 {
+		t.Error("negative memory growth:", stats.MemoryGrowth)
+	}
+	if stats.Inferences != 0 {
+		t.Error("inferences counted without WithInferenceCounter:", stats.Inferences)
+	}
+	if stats != sq.Stats() {
+		t.Error("stats changed after query finished")
+	}
+}
+
+func TestChan(t *testing.T) {
+	ctx := context.Background()
+	pl, err := trealla.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pl.Close()
+
+	t.Run("answers", func(t *testing.T) {
+		var got []trealla.Term
+		for result := range pl.Go(ctx, "between(1, 3, X).") {
+			if result.Err != nil {
+				t.Fatal(result.Err)
+			}
+			got = append(got, result.Answer.Solution["X"])
+		}
+		want := []trealla.Term{int64(1), int64(2), int64(3)}
+		if !reflect.DeepEqual(got, want) {
+			t.Error("unexpected answers. want:", want, "got:", got)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		var results []trealla.Result
+		for result := range pl.Go(ctx, "member(X, [1, 2]), X = 2, throw(ball).") {
+			results = append(results, result)
+		}
+		if len(results) != 1 {
+			t.Fatal("unexpected results:", results)
+		}
+		var ex trealla.ErrThrow
+		if !errors.As(results[0].Err, &ex) {
+			t.Error("unexpected error:", results[0].Err)
+		}
+	})
+
+	t.Run("select", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		q := pl.Query(ctx, "repeat.")
+		ch := q.Chan(ctx)
+		timeout := time.After(50 * time.Millisecond)
+		n := 0
+	loop:
+		for {
+			select {
+			case result, ok := <-ch:
+				if !ok {
+					t.Fatal("channel closed early")
+				}
+				if result.Err != nil {
+					t.Fatal(result.Err)
+				}
+				n++
+			case <-timeout:
+				cancel()
+				break loop
+			}
+		}
+		if n == 0 {
+			t.Error("no answers received")
+		}
+		// drain until the goroutine gives up
+		for range ch {
+		}
+		if _, err := pl.QueryOnce(context.Background(), "true."); err != nil {
+			t.Error("interpreter unusable after cancel:", err)
+		}
+	})
+}
+
+func TestQueryTerm(t *testing.T) {
+	ctx := context.Background()
+	pl, err := trealla.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pl.Close()
+
+	type memberGoal struct {
+		trealla.Functor `prolog:"member/2"`
+		Elem            trealla.Term
+		List            trealla.Term
+	}
+
+	t.Run("compound", func(t *testing.T) {
+		X := trealla.Variable{Name: "X"}
+		goal := trealla.Atom(",").Of(
+			trealla.Atom("=").Of(X, trealla.Atom("it's \"quoted\"")),
+			trealla.Atom("atom_length").Of(X, trealla.Variable{Name: "N"}),
+		)
+		q := pl.QueryTerm(ctx, goal)
+		defer q.Close()
+		if !q.Next(ctx) {
+			t.Fatal(q.Err())
+		}
+		want := trealla.Substitution{"X": trealla.Atom("it's \"quoted\""), "N": int64(13)}
+		if got := q.Current().Solution; !reflect.DeepEqual(got, want) {
+			t.Error("unexpected solution. want:", want, "got:", got)
+		}
+	})
+
+	t.Run("compound struct", func(t *testing.T) {
+		goal := memberGoal{Elem: trealla.Variable{Name: "X"}, List: []trealla.Term{"a b", trealla.Variable{}}}
+		q := pl.QueryTerm(ctx, goal)
+		defer q.Close()
+		var got []trealla.Term
+		for q.Next(ctx) {
+			got = append(got, q.Current().Solution["X"])
+		}
+		if err := q.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 2 || got[0] != "a b" {
+			t.Error("unexpected answers:", got)
+		}
+	})
+
+	t.Run("invalid variable", func(t *testing.T) {
+		q := pl.QueryTerm(ctx, trealla.Atom("true").Of(trealla.Variable{Name: "lower"}))
+		defer q.Close()
+		if q.Next(ctx) || q.Err() == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("invalid term", func(t *testing.T) {
+		q := pl.QueryTerm(ctx, trealla.Atom("call").Of(make(chan int)))
+		defer q.Close()
+		if q.Next(ctx) || q.Err() == nil {
+			t.Error("expected error")
+		}
+	})
+}
@@ -0,0 +1,186 @@
+package trealla
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"slices"
+	"sync"
+)
+
+const snapshotMagic = "trealla-snapshot"
+
+const snapshotVersion = 1
+
+// snapshotHeader is the beginning of a snapshot.
+// It is followed by the names of registered predicates, zombie subqueries, and finally the interpreter's memory.
+type snapshotHeader struct {
+	Magic   [len(snapshotMagic)]byte
+	Version uint32
+	WASM    [sha256.Size]byte // hash of libtpl.wasm
+	Ptr     uint32            // prolog*
+	Pages   uint32
+	Procs   uint32
+	Zombies uint32
+}
+
+var wasmHash = sync.OnceValue(func() [sha256.Size]byte {
+	return sha256.Sum256(tplWASM)
+})
+
+// ErrSnapshotVersion is returned when restoring a snapshot created by a different version of the interpreter.
+var ErrSnapshotVersion = errors.New("trealla: snapshot was created by a different version of the interpreter")
+
+func (pl *prolog) Snapshot(w io.Writer) error {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	if pl.instance == nil {
+		return io.EOF
+	}
+	return pl.snapshot(w)
+}
+
+func (pl *prolog) snapshot(w io.Writer) error {
+	pages, _ := pl.memory.Grow(0)
+
+	// native predicates can't be saved, but we remember their names so they can be re-registered
+	procs := make([]string, 0, len(pl.procs))
+	for pi := range pl.procs {
+		if !isBuiltin(pi) {
+			procs = append(procs, pi)
+		}
+	}
+	slices.Sort(procs)
+
+	// queries running during the snapshot need to be cleaned up when restoring, same as Clone
+	zombies := make([]uint32, 0, len(pl.running)+len(pl.spawning))
+	for pp := range pl.spawning {
+		if ptr := pl.indirect(pp); ptr != 0 {
+			zombies = append(zombies, ptr)
+		}
+	}
+	for ptr := range pl.running {
+		zombies = append(zombies, ptr)
+	}
+
+	hdr := snapshotHeader{
+		Version: snapshotVersion,
+		WASM:    wasmHash(),
+		Ptr:     pl.ptr,
+		Pages:   pages,
+		Procs:   uint32(len(procs)),
+		Zombies: uint32(len(zombies)),
+	}
+	copy(hdr.Magic[:], snapshotMagic)
+
+	bw := bufio.NewWriter(w)
+	if err := binary.Write(bw, binary.LittleEndian, hdr); err != nil {
+		return err
+	}
+	for _, pi := range procs {
+		if err := binary.Write(bw, binary.LittleEndian, uint32(len(pi))); err != nil {
+			return err
+		}
+		if _, err := bw.WriteString(pi); err != nil {
+			return err
+		}
+	}
+	if err := binary.Write(bw, binary.LittleEndian, zombies); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	mem, ok := pl.memory.Read(0, pages*pageSize)
+	if !ok {
+		return fmt.Errorf("trealla: failed to read memory")
+	}
+	_, err := w.Write(mem)
+	return err
+}
+
+// Restore creates a new interpreter from a snapshot created by [Prolog.Snapshot].
+// Options such as [WithPreopenDir] are not saved in snapshots, so pass them again here.
+//
+// Native Go predicates can't be saved either. Predicates registered at the time of the snapshot
+// will throw existence errors until they are registered again with [Prolog.Register] or [Prolog.RegisterNondet].
+func Restore(r io.Reader, opts ...Option) (Prolog, error) {
+	br := bufio.NewReader(r)
+	var hdr snapshotHeader
+	if err := binary.Read(br, binary.LittleEndian, &hdr); err != nil {
+		return nil, fmt.Errorf("trealla: failed to read snapshot header: %w", err)
+	}
+	if string(hdr.Magic[:]) != snapshotMagic {
+		return nil, fmt.Errorf("trealla: not a snapshot")
+	}
+	if hdr.Version != snapshotVersion || hdr.WASM != wasmHash() {
+		return nil, ErrSnapshotVersion
+	}
+
+	procs := make([]string, hdr.Procs)
+	for i := range procs {
+		var size uint32
+		if err := binary.Read(br, binary.LittleEndian, &size); err != nil {
+			return nil, fmt.Errorf("trealla: failed to read snapshot: %w", err)
+		}
+		name := make([]byte, size)
+		if _, err := io.ReadFull(br, name); err != nil {
+			return nil, fmt.Errorf("trealla: failed to read snapshot: %w", err)
+		}
+		procs[i] = string(name)
+	}
+	zombies := make([]uint32, hdr.Zombies)
+	if err := binary.Read(br, binary.LittleEndian, zombies); err != nil {
+		return nil, fmt.Errorf("trealla: failed to read snapshot: %w", err)
+	}
+
+	pl := newProlog(opts...)
+	if err := pl.instantiate(true); err != nil {
+		return nil, err
+	}
+	runtime.SetFinalizer(pl, (*prolog).Close)
+	pl.ptr = hdr.Ptr
+
+	size, _ := pl.memory.Grow(0)
+	if hdr.Pages > size {
+		if _, ok := pl.memory.Grow(hdr.Pages - size); !ok {
+			pl.Close()
+			return nil, fmt.Errorf("trealla: failed to grow memory to %d bytes: %w", hdr.Pages*pageSize, ErrMemoryLimit)
+		}
+	}
+	mem, _ := pl.memory.Read(0, hdr.Pages*pageSize)
+	if _, err := io.ReadFull(br, mem); err != nil {
+		pl.Close()
+		return nil, fmt.Errorf("trealla: failed to read snapshot memory: %w", err)
+	}
+
+	for _, predicate := range builtins {
+		pl.procs[piTerm(Atom(predicate.name), predicate.arity).String()] = predicate.proc
+	}
+	for _, pi := range procs {
+		pl.procs[pi] = nil
+	}
+
+	for _, ptr := range zombies {
+		if _, err := pl.pl_done.Call(pl.ctx, uint64(ptr)); err != nil {
+			pl.Close()
+			return nil, err
+		}
+	}
+
+	return pl, nil
+}
+
+func isBuiltin(pi string) bool {
+	for _, predicate := range builtins {
+		if piTerm(Atom(predicate.name), predicate.arity).String() == pi {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package trealla_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/trealla-prolog/go/trealla"
+)
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	pl, err := trealla.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pl.Close()
+
+	double := func(_ trealla.Prolog, _ trealla.Subquery, goal trealla.Term) trealla.Term {
+		g := goal.(trealla.Compound)
+		return trealla.Atom("double").Of(g.Args[0], g.Args[0].(int64)*2)
+	}
+	if err := pl.Register(ctx, "double", 2, double); err != nil {
+		t.Fatal(err)
+	}
+	if err := pl.ConsultText(ctx, "user", `:- dynamic(counter/1). counter(41).`); err != nil {
+		t.Fatal(err)
+	}
+	// leave a query running during the snapshot
+	running := pl.Query(ctx, "between(1, 3, X).")
+	defer running.Close()
+	if !running.Next(ctx) {
+		t.Fatal(running.Err())
+	}
+
+	var buf bytes.Buffer
+	if err := pl.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := trealla.Restore(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+
+	t.Run("database", func(t *testing.T) {
+		ans, err := restored.QueryOnce(ctx, "retract(counter(N)), N1 is N + 1, assertz(counter(N1)).")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ans.Solution["N1"]; got != int64(42) {
+			t.Error("unexpected answer. want: 42 got:", got)
+		}
+		// original is unaffected
+		ans, err = pl.QueryOnce(ctx, "counter(N).")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ans.Solution["N"]; got != int64(41) {
+			t.Error("original changed. want: 41 got:", got)
+		}
+	})
+
+	t.Run("builtins", func(t *testing.T) {
+		_, err := restored.QueryOnce(ctx, `crypto_data_hash("abc", _, []).`)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("re-register", func(t *testing.T) {
+		_, err := restored.QueryOnce(ctx, "double(2, X).")
+		var ex trealla.ErrThrow
+		if !errors.As(err, &ex) {
+			t.Fatal("expected existence error, got:", err)
+		}
+		if err := restored.Register(ctx, "double", 2, double); err != nil {
+			t.Fatal(err)
+		}
+		ans, err := restored.QueryOnce(ctx, "findall(X, double(2, X), Xs).")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ans.Solution["Xs"]; !reflect.DeepEqual(got, []trealla.Term{int64(4)}) {
+			t.Error("unexpected answer. want: [4] got:", got)
+		}
+	})
+
+	t.Run("bad header", func(t *testing.T) {
+		corrupt := bytes.Clone(buf.Bytes())
+		corrupt[len("trealla-snapshot")+4] ^= 0xFF // flip a byte of the wasm hash
+		if _, err := trealla.Restore(bytes.NewReader(corrupt)); !errors.Is(err, trealla.ErrSnapshotVersion) {
+			t.Error("unexpected error:", err)
+		}
+		if _, err := trealla.Restore(bytes.NewReader([]byte("hello world"))); err == nil {
+			t.Error("expected error for garbage input")
+		}
+	})
+}
@@ -101,8 +101,17 @@ func (c Compound) pi() Compound {
 
 // String returns a Prolog representation of this Compound.
 func (c Compound) String() string {
+	text, err := c.marshal()
+	if err != nil {
+		return fmt.Sprintf("<invalid: %v>", err)
+	}
+	return text
+}
+
+// marshal returns the Prolog text of this Compound, failing if any of its arguments can't be marshaled.
+func (c Compound) marshal() (string, error) {
 	if len(c.Args) == 0 {
-		return c.Functor.String()
+		return c.Functor.String(), nil
 	}
 
 	var buf strings.Builder
@@ -113,16 +122,16 @@ func (c Compound) String() string {
 		case "/", ":":
 			left, err := marshal(c.Args[0])
 			if err != nil {
-				buf.WriteString(fmt.Sprintf("<invalid: %v>", err))
+				return "", err
 			}
 			buf.WriteString(left)
 			buf.WriteString(string(c.Functor))
 			right, err := marshal(c.Args[1])
 			if err != nil {
-				buf.WriteString(fmt.Sprintf("<invalid: %v>", err))
+				return "", err
 			}
 			buf.WriteString(right)
-			return buf.String()
+			return buf.String(), nil
 		}
 	}
 
@@ -134,13 +143,12 @@ func (c Compound) String() string {
 		}
 		text, err := marshal(arg)
 		if err != nil {
-			buf.WriteString(fmt.Sprintf("<invalid: %v>", err))
-			continue
+			return "", err
 		}
 		buf.WriteString(text)
 	}
 	buf.WriteRune(')')
-	return buf.String()
+	return buf.String(), nil
 }
 
 func piTerm(functor Atom, arity int) Compound {
@@ -181,8 +189,17 @@ type compoundStruct interface {
 
 // String returns the Prolog text representation of this variable.
 func (v Variable) String() string {
+	text, err := v.marshal()
+	if err != nil {
+		return fmt.Sprintf("<invalid var: %v>", err)
+	}
+	return text
+}
+
+// marshal returns the Prolog text of this variable, failing if any of its attributes can't be marshaled.
+func (v Variable) marshal() (string, error) {
 	if len(v.Attr) == 0 {
-		return v.Name
+		return v.Name, nil
 	}
 	var sb strings.Builder
 	for i, attr := range v.Attr {
@@ -191,11 +208,11 @@ func (v Variable) String() string {
 		}
 		text, err := marshal(attr)
 		if err != nil {
-			return fmt.Sprintf("<invalid var: %v>", err)
+			return "", err
 		}
 		sb.WriteString(text)
 	}
-	return sb.String()
+	return sb.String(), nil
 }
 
 func numbervars(n int) []Term {
@@ -0,0 +1,203 @@
+package terms
+
+import (
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"math"
+	"math/big"
+	"reflect"
+
+	"github.com/trealla-prolog/go/trealla"
+)
+
+// Compare compares a and b in the standard order of terms, like compare/3.
+// It returns -1 if a comes before b, 0 if they are identical, and +1 if a comes after b.
+//
+// The order is: Var < Number < Atom < Compound.
+//   - Variables are ordered by name.
+//   - Numbers are ordered as in the interpreter: all floats come before all integers and rationals,
+//     then floats are ordered by value, and integers and rationals are ordered by value together.
+//     NaN comes before every other float, and -0.0 is identical to 0.0.
+//   - Atoms are ordered alphabetically by code point.
+//   - Compounds are ordered by arity, then name, then arguments from left to right.
+//
+// Lists are compounds of '.'/2, and the empty list is the atom [].
+// Strings are lists of characters, as in the interpreter and [Unify], so "ab" is identical to [a, b].
+func Compare(a, b trealla.Term) int {
+	a, b = normalize(a), normalize(b)
+	ra, rb := rank(a), rank(b)
+	if ra != rb {
+		return cmp.Compare(ra, rb)
+	}
+
+	switch ra {
+	case rankVar:
+		return cmp.Compare(a.(trealla.Variable).Name, b.(trealla.Variable).Name)
+	case rankNumber:
+		na, _ := number(a)
+		nb, _ := number(b)
+		aFloat, bFloat := numberKind(na) == floatKind, numberKind(nb) == floatKind
+		if aFloat != bFloat {
+			if aFloat {
+				return -1
+			}
+			return 1
+		}
+		return compareNumbers(na, nb)
+	case rankAtom:
+		return cmp.Compare(atomName(a), atomName(b))
+	case rankCompound:
+		ca, cb := compoundOf(a), compoundOf(b)
+		if len(ca.Args) != len(cb.Args) {
+			return cmp.Compare(len(ca.Args), len(cb.Args))
+		}
+		if ca.Functor != cb.Functor {
+			return cmp.Compare(ca.Functor, cb.Functor)
+		}
+		for i := range ca.Args {
+			if c := Compare(ca.Args[i], cb.Args[i]); c != 0 {
+				return c
+			}
+		}
+		return 0
+	}
+	return cmp.Compare(fmt.Sprintf("%#v", a), fmt.Sprintf("%#v", b))
+}
+
+// Hash returns a hash of t that is consistent with [Compare]:
+// terms that compare as identical have the same hash.
+// Hashes are stable across processes, so they can be stored.
+func Hash(t trealla.Term) uint64 {
+	h := fnv.New64a()
+	hashTerm(h, t)
+	return h.Sum64()
+}
+
+func hashTerm(h hash.Hash64, t trealla.Term) {
+	t = normalize(t)
+	r := rank(t)
+	h.Write([]byte{byte(r)})
+	switch r {
+	case rankVar:
+		writeString(h, t.(trealla.Variable).Name)
+	case rankNumber:
+		n, _ := number(t)
+		switch x := n.(type) {
+		case float64:
+			if x == 0 {
+				x = 0 // -0.0 is identical to 0.0
+			}
+			if math.IsNaN(x) {
+				x = math.NaN()
+			}
+			h.Write(binary.LittleEndian.AppendUint64([]byte{'f'}, math.Float64bits(x)))
+		case *big.Int:
+			h.Write([]byte{'i'})
+			writeString(h, x.String())
+		case *big.Rat:
+			h.Write([]byte{'r'})
+			writeString(h, x.String())
+		}
+	case rankAtom:
+		writeString(h, string(atomName(t)))
+	case rankCompound:
+		c := compoundOf(t)
+		writeString(h, string(c.Functor))
+		h.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(c.Args))))
+		for _, arg := range c.Args {
+			hashTerm(h, arg)
+		}
+	default:
+		writeString(h, fmt.Sprintf("%#v", t))
+	}
+}
+
+func writeString(h hash.Hash64, s string) {
+	h.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(s))))
+	h.Write([]byte(s))
+}
+
+type termRank int
+
+const (
+	rankVar termRank = iota
+	rankNumber
+	rankAtom
+	rankCompound
+	rankOther
+)
+
+func rank(t trealla.Term) termRank {
+	switch x := t.(type) {
+	case trealla.Variable:
+		return rankVar
+	case trealla.Atom:
+		return rankAtom
+	case trealla.Compound:
+		if len(x.Args) == 0 {
+			return rankAtom
+		}
+		return rankCompound
+	case []trealla.Term:
+		if len(x) == 0 {
+			return rankAtom
+		}
+		return rankCompound
+	}
+	if _, ok := number(t); ok {
+		return rankNumber
+	}
+	return rankOther
+}
+
+// normalize converts Go types that aren't handled directly, such as strings, other kinds of slices, and compound structs.
+func normalize(t trealla.Term) trealla.Term {
+	switch x := t.(type) {
+	case trealla.Variable, trealla.Atom, trealla.Compound, []trealla.Term:
+		return t
+	case string:
+		list := make([]trealla.Term, 0, len(x))
+		for _, r := range x {
+			list = append(list, trealla.Atom(string(r)))
+		}
+		return list
+	}
+	if _, ok := number(t); ok {
+		return t
+	}
+	rv := reflect.ValueOf(t)
+	if rv.IsValid() && rv.Kind() == reflect.Slice {
+		list := make([]trealla.Term, rv.Len())
+		for i := range list {
+			list[i] = rv.Index(i).Interface()
+		}
+		return list
+	}
+	if text, err := trealla.Marshal(t); err == nil {
+		if parsed, err := trealla.Parse(text); err == nil {
+			return parsed
+		}
+	}
+	return t
+}
+
+func atomName(t trealla.Term) trealla.Atom {
+	switch x := t.(type) {
+	case trealla.Atom:
+		return x
+	case trealla.Compound:
+		return x.Functor
+	}
+	return "[]"
+}
+
+// compoundOf returns t as a compound, with non-empty lists as '.'/2.
+func compoundOf(t trealla.Term) trealla.Compound {
+	if list, ok := t.([]trealla.Term); ok {
+		return trealla.Atom(".").Of(list[0], list[1:])
+	}
+	return t.(trealla.Compound)
+}
@@ -0,0 +1,134 @@
+package terms_test
+
+import (
+	"context"
+	"math"
+	"math/big"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/trealla-prolog/go/trealla"
+	"github.com/trealla-prolog/go/trealla/terms"
+)
+
+func TestCompare(t *testing.T) {
+	X, Y := trealla.Variable{Name: "X"}, trealla.Variable{Name: "Y"}
+	a, b, f := trealla.Atom("a"), trealla.Atom("b"), trealla.Atom("f")
+	huge, _ := new(big.Int).SetString("100000000000000000000", 10)
+
+	table := []struct {
+		a, b trealla.Term
+		want int
+	}{
+		{X, X, 0},
+		{X, Y, -1},
+		{X, int64(1), -1},
+		{int64(1), int64(2), -1},
+		{int64(2), 1.5, 1},
+		{1.0, int64(1), -1},
+		{int64(1), big.NewInt(1), 0},
+		{10.0, int64(1), -1},
+		{huge, math.Inf(-1), 1},
+		{big.NewRat(5, 2), int64(2), 1},
+		{big.NewRat(5, 2), int64(3), -1},
+		{math.NaN(), math.Inf(-1), -1},
+		{big.NewRat(1, 2), 0.5, 1},
+		{big.NewRat(1, 3), int64(1), -1},
+		{int64(99), a, -1},
+		{a, b, -1},
+		{trealla.Atom("é"), trealla.Atom("z"), 1},
+		{b, "a", -1},
+		{"abc", "abd", -1},
+		{"ab", []trealla.Term{a, b}, 0},
+		{"ab", trealla.Atom(".").Of(a, trealla.Atom(".").Of(b, trealla.Atom("[]"))), 0},
+		{"", trealla.Atom("[]"), 0},
+		{"ab", []trealla.Term{a, b, trealla.Atom("c")}, -1},
+		{"b", []trealla.Term{a, trealla.Atom("c")}, 1},
+		{"zzz", f.Of(a), 1},
+		{"ab", f.Of(a, b), -1},
+		{f.Of(b), trealla.Atom("g").Of(a), -1},
+		{trealla.Atom("z").Of(a), f.Of(a, a), -1},
+		{f.Of(a, b), f.Of(a, a), 1},
+		{f.Of(X), f.Of(int64(1)), -1},
+		{f, f.Of(), 0},
+		{[]trealla.Term{}, trealla.Atom("[]"), 0},
+		{[]trealla.Term{a, b}, trealla.Atom(".").Of(a, trealla.Atom(".").Of(b, trealla.Atom("[]"))), 0},
+		{[]trealla.Term{a, b}, []trealla.Term{a}, 1},
+		{[]int64{1, 2}, []trealla.Term{int64(1), int64(2)}, 0},
+		{[]trealla.Term{a}, f.Of(a), 1},
+	}
+	for _, tc := range table {
+		if got := terms.Compare(tc.a, tc.b); got != tc.want {
+			t.Errorf("Compare(%v, %v): want: %d got: %d", tc.a, tc.b, tc.want, got)
+		}
+		if got := terms.Compare(tc.b, tc.a); got != -tc.want {
+			t.Errorf("Compare(%v, %v): want: %d got: %d", tc.b, tc.a, -tc.want, got)
+		}
+		if tc.want == 0 && terms.Hash(tc.a) != terms.Hash(tc.b) {
+			t.Errorf("Hash(%v) != Hash(%v)", tc.a, tc.b)
+		}
+	}
+}
+
+// TestCompareInterpreter checks that sorting with Compare agrees with msort/2.
+func TestCompareInterpreter(t *testing.T) {
+	ctx := context.Background()
+	pl, err := trealla.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pl.Close()
+
+	goals := map[string]string{
+		"terms": `L = [f(b), 3, foo, g(a, b), [1, 2], bar, f(a), -10, 'Zed', [], [1], h(x), f(1), 0.5, 10.0, 1, 2.0, -1.5, f(1.0), "ab", "b", [a, c], "", [a]]`,
+		// the interpreter doesn't order rationals consistently against other types of terms, so they are checked alone
+		"numbers": `findall(N, (member(X, [1, 0.5, 1 rdiv 2, 1.0, 10.0, 3, 7 rdiv 3, -1.5, -5 rdiv 2, -7, 2.0, 10**20]), N is X), L)`,
+	}
+	for name, goal := range goals {
+		t.Run(name, func(t *testing.T) {
+			ans, err := pl.QueryOnce(ctx, goal+`, msort(L, Sorted).`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := slices.Clone(ans.Solution["L"].([]trealla.Term))
+			slices.SortFunc(got, terms.Compare)
+			if want := ans.Solution["Sorted"]; !reflect.DeepEqual(got, want) {
+				t.Errorf("bad order.\nwant: %v\n got: %v", want, got)
+			}
+		})
+	}
+}
+
+func TestHash(t *testing.T) {
+	seen := make(map[uint64]trealla.Term)
+	distinct := []trealla.Term{
+		trealla.Atom("a"),
+		"ab",
+		trealla.Variable{Name: "A"},
+		int64(1),
+		1.0,
+		big.NewRat(1, 2),
+		0.5,
+		trealla.Atom("f").Of(trealla.Atom("a")),
+		trealla.Atom("f").Of(trealla.Atom("a"), trealla.Atom("b")),
+		trealla.Atom("f").Of(trealla.Atom("ab")),
+		[]trealla.Term{trealla.Atom("a")},
+		[]trealla.Term{},
+	}
+	for _, term := range distinct {
+		h := terms.Hash(term)
+		if other, ok := seen[h]; ok {
+			t.Errorf("hash collision: %v and %v", term, other)
+		}
+		seen[h] = term
+	}
+
+	if terms.Hash(0.0) != terms.Hash(math.Copysign(0, -1)) {
+		t.Error("0.0 and -0.0 should have the same hash")
+	}
+	// stable across runs
+	if got, want := terms.Hash(trealla.Atom("f").Of(int64(1))), terms.Hash(trealla.Atom("f").Of(big.NewInt(1))); got != want {
+		t.Errorf("hash mismatch: %x != %x", got, want)
+	}
+}
@@ -0,0 +1,56 @@
+package terms
+
+import "github.com/trealla-prolog/go/trealla"
+
+// And returns the conjunction of goals, in the form of (Goal1, Goal2, ...).
+// With no goals, it returns true. With one goal, it returns that goal.
+func And(goals ...trealla.Term) trealla.Term {
+	return nest(",", "true", goals)
+}
+
+// Or returns the disjunction of goals, in the form of (Goal1 ; Goal2 ; ...).
+// With no goals, it returns fail. With one goal, it returns that goal.
+func Or(goals ...trealla.Term) trealla.Term {
+	return nest(";", "fail", goals)
+}
+
+// Not returns a term in the form of \+ Goal.
+func Not(goal trealla.Term) trealla.Compound {
+	return trealla.Atom(`\+`).Of(goal)
+}
+
+// IfThen returns a term in the form of (If -> Then).
+func IfThen(cond, then trealla.Term) trealla.Compound {
+	return trealla.Atom("->").Of(cond, then)
+}
+
+// IfThenElse returns a term in the form of (If -> Then ; Else).
+func IfThenElse(cond, then, otherwise trealla.Term) trealla.Compound {
+	return trealla.Atom(";").Of(IfThen(cond, then), otherwise)
+}
+
+// Findall returns a term in the form of findall(Template, Goal, List).
+func Findall(template, goal, list trealla.Term) trealla.Compound {
+	return trealla.Atom("findall").Of(template, goal, list)
+}
+
+// Module returns a term in the form of Module:Goal.
+func Module(module trealla.Atom, goal trealla.Term) trealla.Compound {
+	return trealla.Atom(":").Of(module, goal)
+}
+
+// Call returns a term in the form of call(Goal, Args...).
+func Call(goal trealla.Term, args ...trealla.Term) trealla.Compound {
+	return trealla.Atom("call").Of(append([]trealla.Term{goal}, args...)...)
+}
+
+// nest builds a right-associative chain of the binary operator op.
+func nest(op trealla.Atom, empty trealla.Atom, goals []trealla.Term) trealla.Term {
+	switch len(goals) {
+	case 0:
+		return empty
+	case 1:
+		return goals[0]
+	}
+	return op.Of(goals[0], nest(op, empty, goals[1:]))
+}
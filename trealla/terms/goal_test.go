@@ -0,0 +1,76 @@
+package terms_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/trealla-prolog/go/trealla"
+	"github.com/trealla-prolog/go/trealla/terms"
+)
+
+func TestGoal(t *testing.T) {
+	ctx := context.Background()
+	pl, err := trealla.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pl.Close()
+
+	X, Y, Xs := trealla.Variable{Name: "X"}, trealla.Variable{Name: "Y"}, trealla.Variable{Name: "Xs"}
+	table := []struct {
+		name string
+		goal trealla.Term
+		want trealla.Substitution
+	}{
+		{
+			name: "and",
+			goal: terms.And(
+				trealla.Atom("=").Of(X, trealla.Atom("it's")),
+				trealla.Atom("=").Of(Y, "a \"string\""),
+			),
+			want: trealla.Substitution{"X": trealla.Atom("it's"), "Y": "a \"string\""},
+		},
+		{
+			name: "or",
+			goal: terms.Findall(X, terms.Or(
+				trealla.Atom("=").Of(X, int64(1)),
+				trealla.Atom("fail"),
+				trealla.Atom("=").Of(X, int64(2)),
+			), Xs),
+			want: trealla.Substitution{"X": X, "Xs": []trealla.Term{int64(1), int64(2)}},
+		},
+		{
+			name: "not",
+			goal: terms.And(terms.Not(trealla.Atom("fail")), trealla.Atom("=").Of(X, trealla.Atom("ok"))),
+			want: trealla.Substitution{"X": trealla.Atom("ok")},
+		},
+		{
+			name: "if then else",
+			goal: terms.IfThenElse(trealla.Atom("fail"), trealla.Atom("=").Of(X, int64(1)), trealla.Atom("=").Of(X, int64(2))),
+			want: trealla.Substitution{"X": int64(2)},
+		},
+		{
+			name: "module",
+			goal: terms.Module("lists", trealla.Atom("append").Of(trealla.Atom("[]"), []trealla.Term{int64(1)}, X)),
+			want: trealla.Substitution{"X": []trealla.Term{int64(1)}},
+		},
+		{
+			name: "call",
+			goal: terms.Call(trealla.Atom("="), X, terms.And()),
+			want: trealla.Substitution{"X": trealla.Atom("true")},
+		},
+	}
+	for _, tc := range table {
+		t.Run(tc.name, func(t *testing.T) {
+			q := pl.QueryTerm(ctx, tc.goal)
+			defer q.Close()
+			if !q.Next(ctx) {
+				t.Fatal(q.Err())
+			}
+			if got := q.Current().Solution; !reflect.DeepEqual(got, tc.want) {
+				t.Error("unexpected solution. want:", tc.want, "got:", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,395 @@
+package terms
+
+import (
+	"cmp"
+	"math"
+	"math/big"
+	"reflect"
+	"slices"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/trealla-prolog/go/trealla"
+)
+
+// UnifyOption is an option for [Unify].
+type UnifyOption func(*unifier)
+
+// OccursCheck makes unification fail instead of creating cyclic terms, like unify_with_occurs_check/2.
+func OccursCheck() UnifyOption {
+	return func(u *unifier) {
+		u.occursCheck = true
+	}
+}
+
+// Unify unifies a and b, returning the most general unifier of the two terms.
+// It returns false if they don't unify.
+// Bindings in the returned substitution are fully applied, so X = f(Y), Y = 1 results in X bound to f(1).
+//
+// Unification follows the interpreter's rules: variables are identified by name,
+// and each occurrence of the anonymous variable _ is distinct.
+// Double-quoted strings are lists of characters, so "ab" unifies with [a, X].
+// Numbers unify only with numbers of the same type and value, so 1 doesn't unify with 1.0.
+// Attributes of variables are ignored.
+func Unify(a, b trealla.Term, options ...UnifyOption) (trealla.Substitution, bool) {
+	u := newUnifier(options...)
+	if !u.unify(a, b) {
+		return nil, false
+	}
+	return u.substitution(), true
+}
+
+// Subsumes reports whether general subsumes specific, like subsumes_term/2:
+// specific is an instance of general, so they unify without binding any variables of specific.
+func Subsumes(general, specific trealla.Term) bool {
+	// anonymous variables of specific can't be bound either, so give them names
+	n := 0
+	specific = nameAnonymous(specific, &n)
+	u := newUnifier()
+	u.frozen = make(map[string]bool)
+	for _, v := range Vars(specific) {
+		u.frozen[v.Name] = true
+	}
+	return u.unify(general, specific)
+}
+
+func nameAnonymous(t trealla.Term, n *int) trealla.Term {
+	return Map(t, func(sub trealla.Term) trealla.Term {
+		if v, ok := sub.(trealla.Variable); ok && anonymous(v) {
+			*n++
+			// $ can't appear in variable names, so this can't clash with other variables
+			return trealla.Variable{Name: "_$" + strconv.Itoa(*n)}
+		}
+		return sub
+	})
+}
+
+// Apply returns a copy of t with its variables replaced by their bindings in sub.
+// Variables bound to terms that contain them are left in place, instead of creating cyclic terms.
+func Apply(t trealla.Term, sub trealla.Substitution) trealla.Term {
+	return apply(t, sub, nil)
+}
+
+func apply(t trealla.Term, sub trealla.Substitution, seen []string) trealla.Term {
+	switch x := t.(type) {
+	case trealla.Variable:
+		if anonymous(x) || slices.Contains(seen, x.Name) {
+			return x
+		}
+		bound, ok := sub[x.Name]
+		if !ok {
+			return x
+		}
+		return apply(bound, sub, append(seen, x.Name))
+	case trealla.Compound:
+		args := make([]trealla.Term, len(x.Args))
+		for i, arg := range x.Args {
+			args[i] = apply(arg, sub, seen)
+		}
+		if x.Functor == "." && len(args) == 2 {
+			// a partial list whose tail has been bound
+			if tail, ok := args[1].([]trealla.Term); ok {
+				return append([]trealla.Term{args[0]}, tail...)
+			}
+		}
+		return trealla.Compound{Functor: x.Functor, Args: args}
+	case []trealla.Term:
+		list := make([]trealla.Term, len(x))
+		for i, elem := range x {
+			list[i] = apply(elem, sub, seen)
+		}
+		return list
+	}
+	return t
+}
+
+type unifier struct {
+	bindings    map[string]trealla.Term
+	frozen      map[string]bool
+	occursCheck bool
+	// seen holds pairs of compound terms and lists already being unified.
+	// Without the occurs check bindings can be cyclic, and pairs of cyclic terms
+	// are unified once instead of forever, as rational trees.
+	seen map[[2]cell]bool
+}
+
+// cell identifies the arguments of a compound term or the elements of a list by their address.
+type cell struct {
+	ptr uintptr
+	len int
+}
+
+// cellOf returns the identity of t's arguments or elements, if it has any.
+func cellOf(t trealla.Term) (cell, bool) {
+	if c, ok := t.(trealla.Compound); ok {
+		t = c.Args
+	}
+	rv := reflect.ValueOf(t)
+	if !rv.IsValid() || rv.Kind() != reflect.Slice || rv.Len() == 0 {
+		return cell{}, false
+	}
+	return cell{ptr: rv.Pointer(), len: rv.Len()}, true
+}
+
+func newUnifier(options ...UnifyOption) *unifier {
+	u := &unifier{bindings: make(map[string]trealla.Term)}
+	for _, opt := range options {
+		opt(u)
+	}
+	return u
+}
+
+// deref follows the bindings of variables.
+func (u *unifier) deref(t trealla.Term) trealla.Term {
+	for {
+		v, ok := t.(trealla.Variable)
+		if !ok || anonymous(v) {
+			return t
+		}
+		bound, ok := u.bindings[v.Name]
+		if !ok {
+			return t
+		}
+		t = bound
+	}
+}
+
+func (u *unifier) unify(a, b trealla.Term) bool {
+	a, b = u.deref(a), u.deref(b)
+
+	va, aVar := a.(trealla.Variable)
+	vb, bVar := b.(trealla.Variable)
+	switch {
+	case aVar && anonymous(va), bVar && anonymous(vb):
+		return true
+	case aVar && bVar && va.Name == vb.Name:
+		return true
+	case aVar && !u.frozen[va.Name]:
+		return u.bind(va, b)
+	case bVar && !u.frozen[vb.Name]:
+		return u.bind(vb, a)
+	case aVar || bVar:
+		// variables of the specific term in Subsumes only match themselves
+		return false
+	}
+
+	if ka, ok := cellOf(a); ok {
+		if kb, ok := cellOf(b); ok {
+			pair := [2]cell{ka, kb}
+			if u.seen[pair] {
+				// any mismatch will be found where this pair was first visited
+				return true
+			}
+			if u.seen == nil {
+				u.seen = make(map[[2]cell]bool)
+			}
+			u.seen[pair] = true
+		}
+	}
+
+	aList, aEmpty, aOK := listCell(a)
+	bList, bEmpty, bOK := listCell(b)
+	if aOK || bOK {
+		switch {
+		case !aOK || !bOK:
+			return false
+		case aEmpty || bEmpty:
+			return aEmpty && bEmpty
+		}
+		return u.unify(aList[0], bList[0]) && u.unify(aList[1], bList[1])
+	}
+
+	ca, aCompound := compound(a)
+	cb, bCompound := compound(b)
+	if aCompound || bCompound {
+		if !aCompound || !bCompound || ca.Functor != cb.Functor || len(ca.Args) != len(cb.Args) {
+			return false
+		}
+		for i := range ca.Args {
+			if !u.unify(ca.Args[i], cb.Args[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return atomicEqual(a, b)
+}
+
+func (u *unifier) bind(v trealla.Variable, t trealla.Term) bool {
+	if u.occursCheck && u.occurs(v.Name, t) {
+		return false
+	}
+	u.bindings[v.Name] = t
+	return true
+}
+
+func (u *unifier) occurs(name string, t trealla.Term) bool {
+	t = u.deref(t)
+	switch x := t.(type) {
+	case trealla.Variable:
+		return x.Name == name
+	case trealla.Compound:
+		for _, arg := range x.Args {
+			if u.occurs(name, arg) {
+				return true
+			}
+		}
+	case []trealla.Term:
+		for _, elem := range x {
+			if u.occurs(name, elem) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (u *unifier) substitution() trealla.Substitution {
+	sub := make(trealla.Substitution, len(u.bindings))
+	for name := range u.bindings {
+		sub[name] = apply(trealla.Variable{Name: name}, u.bindings, nil)
+	}
+	return sub
+}
+
+func anonymous(v trealla.Variable) bool {
+	return v.Name == "_" || v.Name == ""
+}
+
+// listCell returns the head and tail of a non-empty list, or reports whether t is the empty list.
+// The last return value reports whether t is a list cell or the empty list.
+func listCell(t trealla.Term) (cell [2]trealla.Term, empty bool, ok bool) {
+	switch x := t.(type) {
+	case []trealla.Term:
+		if len(x) == 0 {
+			return cell, true, true
+		}
+		return [2]trealla.Term{x[0], x[1:]}, false, true
+	case string:
+		if x == "" {
+			return cell, true, true
+		}
+		r, size := utf8.DecodeRuneInString(x)
+		return [2]trealla.Term{trealla.Atom(string(r)), x[size:]}, false, true
+	case trealla.Atom:
+		return cell, x == "[]", x == "[]"
+	case trealla.Compound:
+		if x.Functor == "." && len(x.Args) == 2 {
+			return [2]trealla.Term{x.Args[0], x.Args[1]}, false, true
+		}
+		return cell, false, false
+	}
+
+	rv := reflect.ValueOf(t)
+	if rv.IsValid() && rv.Kind() == reflect.Slice {
+		if rv.Len() == 0 {
+			return cell, true, true
+		}
+		return [2]trealla.Term{rv.Index(0).Interface(), rv.Slice(1, rv.Len()).Interface()}, false, true
+	}
+	return cell, false, false
+}
+
+// compound returns t as a compound with at least one argument.
+func compound(t trealla.Term) (trealla.Compound, bool) {
+	c, ok := t.(trealla.Compound)
+	if !ok || len(c.Args) == 0 {
+		return c, false
+	}
+	return c, true
+}
+
+func atomicEqual(a, b trealla.Term) bool {
+	if ca, ok := a.(trealla.Compound); ok {
+		a = ca.Functor
+	}
+	if cb, ok := b.(trealla.Compound); ok {
+		b = cb.Functor
+	}
+	na, aNum := number(a)
+	nb, bNum := number(b)
+	if aNum || bNum {
+		if !aNum || !bNum {
+			return false
+		}
+		return numberKind(na) == numberKind(nb) && compareNumbers(na, nb) == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// number normalizes integers to *big.Int and floats to float64.
+func number(t trealla.Term) (trealla.Term, bool) {
+	switch x := t.(type) {
+	case int64:
+		return big.NewInt(x), true
+	case int:
+		return big.NewInt(int64(x)), true
+	case uint64:
+		return new(big.Int).SetUint64(x), true
+	case uint:
+		return new(big.Int).SetUint64(uint64(x)), true
+	case *big.Int:
+		return x, true
+	case *big.Rat:
+		if x.IsInt() {
+			return x.Num(), true
+		}
+		return x, true
+	case float64:
+		return x, true
+	case float32:
+		return float64(x), true
+	}
+	return nil, false
+}
+
+type numKind int
+
+const (
+	floatKind numKind = iota
+	intKind
+	ratKind
+)
+
+func numberKind(n trealla.Term) numKind {
+	switch n.(type) {
+	case *big.Int:
+		return intKind
+	case *big.Rat:
+		return ratKind
+	}
+	return floatKind
+}
+
+// compareNumbers compares normalized numbers by value.
+// NaN is less than every other number.
+func compareNumbers(a, b trealla.Term) int {
+	fa, aFloat := a.(float64)
+	fb, bFloat := b.(float64)
+	switch {
+	case aFloat && bFloat:
+		return cmp.Compare(fa, fb)
+	case aFloat && math.IsNaN(fa):
+		return -1
+	case bFloat && math.IsNaN(fb):
+		return 1
+	case aFloat && math.IsInf(fa, 0):
+		return int(math.Copysign(1, fa))
+	case bFloat && math.IsInf(fb, 0):
+		return -int(math.Copysign(1, fb))
+	}
+	return toRat(a).Cmp(toRat(b))
+}
+
+func toRat(n trealla.Term) *big.Rat {
+	switch x := n.(type) {
+	case *big.Int:
+		return new(big.Rat).SetInt(x)
+	case *big.Rat:
+		return x
+	case float64:
+		return new(big.Rat).SetFloat64(x)
+	}
+	return new(big.Rat)
+}
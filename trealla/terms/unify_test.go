@@ -0,0 +1,161 @@
+package terms_test
+
+import (
+	"context"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/trealla-prolog/go/trealla"
+	"github.com/trealla-prolog/go/trealla/terms"
+)
+
+func TestUnify(t *testing.T) {
+	X, Y, Z := trealla.Variable{Name: "X"}, trealla.Variable{Name: "Y"}, trealla.Variable{Name: "Z"}
+	_X := trealla.Variable{Name: "_"}
+	f, g := trealla.Atom("f"), trealla.Atom("g")
+
+	table := []struct {
+		name string
+		a, b trealla.Term
+		want trealla.Substitution // nil means failure
+	}{
+		{"atoms", trealla.Atom("a"), trealla.Atom("a"), trealla.Substitution{}},
+		{"different atoms", trealla.Atom("a"), trealla.Atom("b"), nil},
+		{"variable", X, trealla.Atom("a"), trealla.Substitution{"X": trealla.Atom("a")}},
+		{"compound", f.Of(X, trealla.Atom("b")), f.Of(trealla.Atom("a"), Y), trealla.Substitution{"X": trealla.Atom("a"), "Y": trealla.Atom("b")}},
+		{"arity", f.Of(X), f.Of(X, Y), nil},
+		{"functor", f.Of(X), g.Of(X), nil},
+		{"chain", f.Of(X, Y, Y), f.Of(g.Of(Y), Z, int64(1)), trealla.Substitution{"X": g.Of(int64(1)), "Y": int64(1), "Z": int64(1)}},
+		{"repeated", f.Of(X, X), f.Of(trealla.Atom("a"), trealla.Atom("b")), nil},
+		{"anonymous", f.Of(_X, _X), f.Of(trealla.Atom("a"), trealla.Atom("b")), trealla.Substitution{}},
+		{"int float", int64(1), 1.0, nil},
+		{"big int", big.NewInt(1), int64(1), trealla.Substitution{}},
+		{"rational", big.NewRat(1, 3), big.NewRat(2, 6), trealla.Substitution{}},
+		{"list", []trealla.Term{X, int64(2)}, []trealla.Term{int64(1), Y}, trealla.Substitution{"X": int64(1), "Y": int64(2)}},
+		{"list length", []trealla.Term{X}, []trealla.Term{int64(1), int64(2)}, nil},
+		{"partial list", trealla.Atom(".").Of(X, Y), []trealla.Term{int64(1), int64(2)}, trealla.Substitution{"X": int64(1), "Y": []trealla.Term{int64(2)}}},
+		{"string", "ab", []trealla.Term{trealla.Atom("a"), X}, trealla.Substitution{"X": trealla.Atom("b")}},
+		{"empty list", trealla.Atom("[]"), []trealla.Term{}, trealla.Substitution{}},
+		{"empty string", "", []trealla.Term{}, trealla.Substitution{}},
+		{"go slice", []int64{1, 2}, []trealla.Term{int64(1), X}, trealla.Substitution{"X": int64(2)}},
+		{"cyclic", X, f.Of(X), trealla.Substitution{"X": f.Of(X)}},
+		{"cyclic pair", f.Of(X, Y, X), f.Of(g.Of(X), g.Of(Y), Y), trealla.Substitution{"X": g.Of(X), "Y": g.Of(Y)}},
+		{"cyclic pair mismatch", f.Of(X, Y, X), f.Of(g.Of(X), f.Of(Y), Y), nil},
+		{"cyclic lists", f.Of(X, Y, X), f.Of([]trealla.Term{X}, []trealla.Term{Y}, Y), trealla.Substitution{"X": []trealla.Term{X}, "Y": []trealla.Term{Y}}},
+	}
+	for _, tc := range table {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := terms.Unify(tc.a, tc.b)
+			if ok != (tc.want != nil) {
+				t.Fatalf("bad result. want: %v got: %v (%v)", tc.want != nil, ok, got)
+			}
+			if ok && !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("bad unifier. want: %v got: %v", tc.want, got)
+			}
+		})
+	}
+
+	t.Run("occurs check", func(t *testing.T) {
+		if _, ok := terms.Unify(X, f.Of(X), terms.OccursCheck()); ok {
+			t.Error("expected failure")
+		}
+		if _, ok := terms.Unify(f.Of(X, Y), f.Of(Y, g.Of(X)), terms.OccursCheck()); ok {
+			t.Error("expected failure")
+		}
+		if _, ok := terms.Unify(f.Of(X, Y), f.Of(Y, g.Of(Z)), terms.OccursCheck()); !ok {
+			t.Error("expected success")
+		}
+	})
+}
+
+// TestUnifyInterpreter checks that Unify agrees with the interpreter.
+func TestUnifyInterpreter(t *testing.T) {
+	ctx := context.Background()
+	pl, err := trealla.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pl.Close()
+
+	texts := [][2]string{
+		{"f(X, b, [Y|T])", "f(a, B, [1, 2, 3])"},
+		{"[X, Y|Z]", `"hello"`},
+		{"f(X, X)", "f(1, 2)"},
+		{"g(A, B, C)", "g(B, C, done)"},
+		{"h(N, 0'a)", "h(0x10, C)"},
+		{"'[]'", "[]"},
+	}
+	for _, tc := range texts {
+		t.Run(tc[0]+" = "+tc[1], func(t *testing.T) {
+			a, err := trealla.Parse(tc[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := trealla.Parse(tc[1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := terms.Unify(a, b)
+
+			q := pl.QueryTerm(ctx, trealla.Atom("=").Of(a, b))
+			defer q.Close()
+			if q.Next(ctx) != ok {
+				t.Fatalf("disagreement. interpreter: %v Unify: %v (%v)", !ok, ok, q.Err())
+			}
+			if !ok {
+				return
+			}
+			want := q.Current().Solution
+			for name, value := range want {
+				if !reflect.DeepEqual(got[name], value) {
+					t.Errorf("bad binding for %s. want: %v got: %v", name, value, got[name])
+				}
+			}
+		})
+	}
+}
+
+func TestSubsumes(t *testing.T) {
+	X, Y, Z := trealla.Variable{Name: "X"}, trealla.Variable{Name: "Y"}, trealla.Variable{Name: "Z"}
+	f := trealla.Atom("f")
+
+	table := []struct {
+		general, specific trealla.Term
+		want              bool
+	}{
+		{f.Of(X, Y), f.Of(Z, Z), true},
+		{f.Of(Z, Z), f.Of(X, Y), false},
+		{X, f.Of(X), false},
+		{f.Of(X), f.Of(X), true},
+		{f.Of(X, trealla.Atom("a")), f.Of(int64(1), trealla.Atom("a")), true},
+		{f.Of(trealla.Atom("a")), f.Of(trealla.Variable{Name: "_"}), false},
+		{[]trealla.Term{X, X}, []trealla.Term{int64(1), int64(1)}, true},
+		{[]trealla.Term{X, X}, []trealla.Term{int64(1), int64(2)}, false},
+	}
+	for _, tc := range table {
+		if got := terms.Subsumes(tc.general, tc.specific); got != tc.want {
+			t.Errorf("Subsumes(%v, %v): want: %v got: %v", tc.general, tc.specific, tc.want, got)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	X, Y, T := trealla.Variable{Name: "X"}, trealla.Variable{Name: "Y"}, trealla.Variable{Name: "T"}
+	sub := trealla.Substitution{
+		"X": trealla.Atom("g").Of(Y),
+		"Y": int64(1),
+		"T": []trealla.Term{int64(3)},
+	}
+	term := trealla.Atom("f").Of(X, trealla.Atom(".").Of(int64(2), T), trealla.Variable{Name: "Unbound"})
+	want := trealla.Atom("f").Of(trealla.Atom("g").Of(int64(1)), []trealla.Term{int64(2), int64(3)}, trealla.Variable{Name: "Unbound"})
+	if got := terms.Apply(term, sub); !reflect.DeepEqual(got, want) {
+		t.Errorf("bad apply. want: %v got: %v", want, got)
+	}
+
+	// cycles are left alone
+	cyclic := trealla.Substitution{"X": trealla.Atom("f").Of(X)}
+	if got, want := terms.Apply(X, cyclic), trealla.Atom("f").Of(X); !reflect.DeepEqual(got, want) {
+		t.Errorf("bad apply. want: %v got: %v", want, got)
+	}
+}
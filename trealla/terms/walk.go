@@ -0,0 +1,162 @@
+package terms
+
+import (
+	"reflect"
+	"slices"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/trealla-prolog/go/trealla"
+)
+
+// Walk traverses t depth-first, calling fn for t and then for each of its subterms from left to right.
+// The subterms of a compound are its arguments, and the subterms of a list are its elements,
+// including lists of any Go slice type such as []int64.
+// Strings are not traversed as lists of characters.
+//
+// The path of a subterm is the index of each argument or element leading to it from t,
+// so the path of t itself is empty. The path is only valid during the call to fn.
+// If fn returns false, the subterms of sub are skipped.
+func Walk(t trealla.Term, fn func(path []int, sub trealla.Term) bool) {
+	walk(t, make([]int, 0, 8), fn)
+}
+
+func walk(t trealla.Term, path []int, fn func([]int, trealla.Term) bool) {
+	if !fn(path, t) {
+		return
+	}
+	for i, child := range subterms(t) {
+		walk(child, append(path, i), fn)
+	}
+}
+
+// subterms returns the arguments of a compound or the elements of a list.
+func subterms(t trealla.Term) []trealla.Term {
+	switch x := t.(type) {
+	case trealla.Compound:
+		return x.Args
+	case []trealla.Term:
+		return x
+	case string, trealla.Atom, trealla.Variable:
+		return nil
+	}
+	rv := reflect.ValueOf(t)
+	if !rv.IsValid() || rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil
+	}
+	elems := make([]trealla.Term, rv.Len())
+	for i := range elems {
+		elems[i] = rv.Index(i).Interface()
+	}
+	return elems
+}
+
+// Map returns a copy of t with fn applied to every subterm, from the bottom up:
+// fn is called for the arguments of a compound or elements of a list before the compound or list itself,
+// which is rebuilt from the results.
+// Lists of any Go slice type become []trealla.Term.
+func Map(t trealla.Term, fn func(trealla.Term) trealla.Term) trealla.Term {
+	if c, ok := t.(trealla.Compound); ok {
+		args := make([]trealla.Term, len(c.Args))
+		for i, arg := range c.Args {
+			args[i] = Map(arg, fn)
+		}
+		return fn(trealla.Compound{Functor: c.Functor, Args: args})
+	}
+	elems := subterms(t)
+	if elems == nil {
+		return fn(t)
+	}
+	list := make([]trealla.Term, len(elems))
+	for i, elem := range elems {
+		list[i] = Map(elem, fn)
+	}
+	return fn(list)
+}
+
+// Vars returns the variables of t in depth-first order, like term_variables/2.
+// Each variable is returned once, except for the anonymous variable _, which is distinct every time it occurs.
+func Vars(t trealla.Term) []trealla.Variable {
+	var vars []trealla.Variable
+	Walk(t, func(_ []int, sub trealla.Term) bool {
+		v, ok := sub.(trealla.Variable)
+		if !ok {
+			return true
+		}
+		seen := slices.ContainsFunc(vars, func(other trealla.Variable) bool {
+			return other.Name == v.Name
+		})
+		if anonymous(v) || !seen {
+			vars = append(vars, v)
+		}
+		return true
+	})
+	return vars
+}
+
+// Ground reports whether t contains no variables, like ground/1.
+func Ground(t trealla.Term) bool {
+	ground := true
+	Walk(t, func(_ []int, sub trealla.Term) bool {
+		if _, ok := sub.(trealla.Variable); ok {
+			ground = false
+		}
+		return ground
+	})
+	return ground
+}
+
+var copies atomic.Uint64
+
+// CopyTerm returns a copy of t with its variables renamed to fresh variables, like copy_term/2.
+// Occurrences of the same variable are renamed consistently, and the new names don't occur in t
+// or in other copies, so the copy can be unified with t without sharing variables.
+// Attributes of variables are copied, renaming the variables they refer to.
+func CopyTerm(t trealla.Term) trealla.Term {
+	used := make(map[string]bool)
+	for _, v := range Vars(t) {
+		used[v.Name] = true
+	}
+	fresh := make(map[string]string)
+	var rename func(trealla.Term) trealla.Term
+	rename = func(sub trealla.Term) trealla.Term {
+		v, ok := sub.(trealla.Variable)
+		if !ok || anonymous(v) {
+			return sub
+		}
+		name, ok := fresh[v.Name]
+		if !ok {
+			for name == "" || used[name] {
+				name = "_G" + strconv.FormatUint(copies.Add(1), 10)
+			}
+			fresh[v.Name] = name
+		}
+		var attr []trealla.Term
+		for _, a := range v.Attr {
+			attr = append(attr, Map(a, rename))
+		}
+		return trealla.Variable{Name: name, Attr: attr}
+	}
+	return Map(t, rename)
+}
+
+// Size returns the number of subterms of t, including t itself, as visited by [Walk].
+func Size(t trealla.Term) int {
+	n := 0
+	Walk(t, func(_ []int, _ trealla.Term) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// Depth returns the depth of t: 1 for atomic terms, variables, and empty lists,
+// or 1 more than the depth of the deepest argument of a compound or element of a list.
+func Depth(t trealla.Term) int {
+	depth := 0
+	Walk(t, func(path []int, _ trealla.Term) bool {
+		depth = max(depth, len(path)+1)
+		return true
+	})
+	return depth
+}
@@ -0,0 +1,154 @@
+package terms_test
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/trealla-prolog/go/trealla"
+	"github.com/trealla-prolog/go/trealla/terms"
+)
+
+func TestWalk(t *testing.T) {
+	X := trealla.Variable{Name: "X"}
+	term := trealla.Atom("f").Of(trealla.Atom("a"), []trealla.Term{X, trealla.Atom("g").Of(int64(1))}, []int64{2})
+
+	var got []string
+	terms.Walk(term, func(path []int, sub trealla.Term) bool {
+		got = append(got, fmt.Sprint(path, " ", sub))
+		return true
+	})
+	want := []string{
+		"[] f(a, [X, g(1)], [2])",
+		"[0] a",
+		"[1] [X g(1)]",
+		"[1 0] X",
+		"[1 1] g(1)",
+		"[1 1 0] 1",
+		"[2] [2]",
+		"[2 0] 2",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("bad walk.\nwant: %q\n got: %q", want, got)
+	}
+
+	// skipping subterms
+	var visited int
+	terms.Walk(term, func(path []int, sub trealla.Term) bool {
+		visited++
+		return len(path) == 0
+	})
+	if visited != 4 {
+		t.Error("expected 4 visits, got:", visited)
+	}
+}
+
+func TestMap(t *testing.T) {
+	X, Y := trealla.Variable{Name: "X"}, trealla.Variable{Name: "Y"}
+	term := trealla.Atom("f").Of(X, []trealla.Term{Y, int64(1)}, []int64{2, 3})
+
+	// double every integer, and bind X
+	got := terms.Map(term, func(sub trealla.Term) trealla.Term {
+		switch x := sub.(type) {
+		case int64:
+			return x * 2
+		case trealla.Variable:
+			if x.Name == "X" {
+				return trealla.Atom("x")
+			}
+		}
+		return sub
+	})
+	want := trealla.Atom("f").Of(trealla.Atom("x"), []trealla.Term{Y, int64(2)}, []trealla.Term{int64(4), int64(6)})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("bad map. want: %v got: %v", want, got)
+	}
+
+	// bottom up
+	var order []string
+	terms.Map(trealla.Atom("f").Of(trealla.Atom("g").Of(trealla.Atom("a"))), func(sub trealla.Term) trealla.Term {
+		order = append(order, fmt.Sprint(sub))
+		return sub
+	})
+	if want := []string{"a", "g(a)", "f(g(a))"}; !slices.Equal(order, want) {
+		t.Errorf("bad order. want: %v got: %v", want, order)
+	}
+}
+
+func TestVars(t *testing.T) {
+	X, Y, _X := trealla.Variable{Name: "X"}, trealla.Variable{Name: "Y"}, trealla.Variable{Name: "_"}
+	term := trealla.Atom("f").Of(X, []trealla.Term{Y, _X, X}, _X, "X")
+	want := []trealla.Variable{X, Y, _X, _X}
+	if got := terms.Vars(term); !reflect.DeepEqual(got, want) {
+		t.Errorf("bad vars. want: %v got: %v", want, got)
+	}
+
+	if terms.Ground(term) {
+		t.Error("term with variables is ground")
+	}
+	if !terms.Ground(trealla.Atom("f").Of("X", []int64{1}, trealla.Atom("X"))) {
+		t.Error("term without variables isn't ground")
+	}
+}
+
+func TestCopyTerm(t *testing.T) {
+	X, Y, _X := trealla.Variable{Name: "X"}, trealla.Variable{Name: "Y"}, trealla.Variable{Name: "_"}
+	term := trealla.Atom("f").Of(X, Y, X, _X, trealla.Atom("a"))
+
+	copied := terms.CopyTerm(term).(trealla.Compound)
+	vars := terms.Vars(copied)
+	if len(vars) != 3 {
+		t.Fatal("unexpected variables:", vars)
+	}
+	if vars[0].Name == "X" || vars[1].Name == "Y" || vars[0].Name == vars[1].Name {
+		t.Error("variables weren't renamed:", copied)
+	}
+	if !reflect.DeepEqual(copied.Args[0], copied.Args[2]) {
+		t.Error("variables weren't renamed consistently:", copied)
+	}
+	if !reflect.DeepEqual(copied.Args[3], _X) || copied.Args[4] != trealla.Atom("a") {
+		t.Error("unexpected copy:", copied)
+	}
+
+	// copies don't share variables with each other or the original
+	again := terms.CopyTerm(term)
+	sub, ok := terms.Unify(copied, again)
+	if !ok || len(sub) != 2 {
+		t.Error("copies share variables:", copied, again)
+	}
+	if !terms.Subsumes(term, copied) || !terms.Subsumes(copied, term) {
+		t.Error("copy isn't a variant of the original:", copied)
+	}
+
+	// attributes
+	attributed := trealla.Variable{Name: "X", Attr: []trealla.Term{trealla.Atom("dif").Of(X, Y)}}
+	v := terms.CopyTerm(trealla.Atom("f").Of(attributed, Y)).(trealla.Compound)
+	newX, newY := v.Args[0].(trealla.Variable), v.Args[1].(trealla.Variable)
+	if want := []trealla.Term{trealla.Atom("dif").Of(trealla.Variable{Name: newX.Name}, newY)}; !reflect.DeepEqual(newX.Attr, want) {
+		t.Errorf("bad attributes. want: %v got: %v", want, newX.Attr)
+	}
+}
+
+func TestSizeDepth(t *testing.T) {
+	table := []struct {
+		term  trealla.Term
+		size  int
+		depth int
+	}{
+		{trealla.Atom("a"), 1, 1},
+		{"string", 1, 1},
+		{[]trealla.Term{}, 1, 1},
+		{trealla.Atom("f").Of(trealla.Atom("a"), trealla.Atom("b")), 3, 2},
+		{trealla.Atom("f").Of(trealla.Atom("g").Of(trealla.Atom("h").Of(int64(1))), int64(2)), 5, 4},
+		{[]int64{1, 2, 3}, 4, 2},
+	}
+	for _, tc := range table {
+		if got := terms.Size(tc.term); got != tc.size {
+			t.Errorf("Size(%v): want: %d got: %d", tc.term, tc.size, got)
+		}
+		if got := terms.Depth(tc.term); got != tc.depth {
+			t.Errorf("Depth(%v): want: %d got: %d", tc.term, tc.depth, got)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package trealla
+
+import (
+	"context"
+	"iter"
+)
+
+// QueryAll executes goal and scans the solution of every answer into a T with [Substitution.Scan].
+// T must be a struct or a map.
+// A query that fails returns no results and a nil error.
+func QueryAll[T any](ctx context.Context, pl Prolog, goal string, options ...QueryOption) ([]T, error) {
+	var results []T
+	for result, err := range QuerySeq[T](ctx, pl, goal, options...) {
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// QueryOneAs executes goal and scans the solution of its first answer into a T with [Substitution.Scan].
+// T must be a struct or a map.
+// A query that fails returns [ErrFailure], like [Prolog.QueryOnce].
+func QueryOneAs[T any](ctx context.Context, pl Prolog, goal string, options ...QueryOption) (T, error) {
+	var result T
+	ans, err := pl.QueryOnce(ctx, goal, options...)
+	if err != nil {
+		return result, err
+	}
+	err = ans.Solution.Scan(&result)
+	return result, err
+}
+
+// QuerySeq executes goal and returns an iterator that scans the solution of each answer into a T with [Substitution.Scan].
+// T must be a struct or a map.
+// Iteration stops after the first error. A query that fails yields nothing.
+// This is a single-use iterator.
+func QuerySeq[T any](ctx context.Context, pl Prolog, goal string, options ...QueryOption) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		q := pl.Query(ctx, goal, options...)
+		defer q.Close()
+		for q.Next(ctx) {
+			var result T
+			if err := q.Current().Solution.Scan(&result); err != nil {
+				yield(result, err)
+				return
+			}
+			if !yield(result, nil) {
+				return
+			}
+		}
+		if err := q.Err(); err != nil && !IsFailure(err) {
+			var zero T
+			yield(zero, err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package trealla_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/trealla-prolog/go/trealla"
+)
+
+func TestQueryAll(t *testing.T) {
+	ctx := context.Background()
+	pl, err := trealla.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pl.Close()
+
+	type pair struct {
+		X int
+		Y string
+	}
+
+	t.Run("all", func(t *testing.T) {
+		got, err := trealla.QueryAll[pair](ctx, pl, `member(X-Y, [1-"a", 2-"b"]).`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []pair{{1, "a"}, {2, "b"}}
+		if !reflect.DeepEqual(got, want) {
+			t.Error("unexpected results. want:", want, "got:", got)
+		}
+	})
+
+	t.Run("map", func(t *testing.T) {
+		got, err := trealla.QueryAll[map[string]trealla.Term](ctx, pl, `X = 1 ; X = 2.`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []map[string]trealla.Term{{"X": int64(1)}, {"X": int64(2)}}
+		if !reflect.DeepEqual(got, want) {
+			t.Error("unexpected results. want:", want, "got:", got)
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		got, err := trealla.QueryAll[pair](ctx, pl, `false.`)
+		if err != nil || len(got) != 0 {
+			t.Error("unexpected result:", got, err)
+		}
+	})
+
+	t.Run("throw", func(t *testing.T) {
+		got, err := trealla.QueryAll[pair](ctx, pl, `(X = 1 ; throw(ball)), Y = "a".`)
+		var ex trealla.ErrThrow
+		if !errors.As(err, &ex) {
+			t.Error("unexpected error:", err)
+		}
+		if want := []pair{{1, "a"}}; !reflect.DeepEqual(got, want) {
+			t.Error("unexpected results. want:", want, "got:", got)
+		}
+	})
+
+	t.Run("one", func(t *testing.T) {
+		got, err := trealla.QueryOneAs[pair](ctx, pl, `X = 1, Y = "a" ; X = 2, Y = "b".`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := (pair{1, "a"}); got != want {
+			t.Error("unexpected result. want:", want, "got:", got)
+		}
+		if _, err := trealla.QueryOneAs[pair](ctx, pl, `false.`); !trealla.IsFailure(err) {
+			t.Error("unexpected error:", err)
+		}
+	})
+
+	t.Run("seq", func(t *testing.T) {
+		var got []int
+		for p, err := range trealla.QuerySeq[pair](ctx, pl, `length(_, X), Y = "y".`) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, p.X)
+			if len(got) == 3 {
+				break
+			}
+		}
+		if want := []int{0, 1, 2}; !reflect.DeepEqual(got, want) {
+			t.Error("unexpected results. want:", want, "got:", got)
+		}
+	})
+}
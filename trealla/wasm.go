@@ -3,9 +3,12 @@ package trealla
 import (
 	"context"
 	_ "embed"
+	"errors"
+	"sync"
 
 	"github.com/tetratelabs/wazero"
 	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/experimental"
 	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
 )
 
@@ -14,15 +17,33 @@ var tplWASM []byte
 
 type wasmFunc = api.Function
 
-var wasmEngine wazero.Runtime
-var wasmModule wazero.CompiledModule
+// engine is a wasm runtime with the interpreter compiled for it.
+type engine struct {
+	runtime wazero.Runtime
+	module  wazero.CompiledModule
+	// metered is the interpreter compiled with an inference counter, see [WithInferenceCounter].
+	// It's compiled on first use because counting makes all wasm code slower.
+	metered func() wazero.CompiledModule
+}
+
+var wasmEngine *engine
+
+// stoppableEngine is used by interpreters created with [WithHardStop].
+// It's created on first use because closing on context done makes all wasm code slower.
+var stoppableEngine = sync.OnceValue(func() *engine {
+	return newEngine(wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+})
 
 func init() {
+	wasmEngine = newEngine(wazero.NewRuntimeConfig())
+}
+
+func newEngine(cfg wazero.RuntimeConfig) *engine {
 	ctx := context.Background()
-	wasmEngine = wazero.NewRuntime(ctx)
-	wasi_snapshot_preview1.MustInstantiate(ctx, wasmEngine)
+	runtime := wazero.NewRuntimeWithConfig(ctx, cfg)
+	wasi_snapshot_preview1.MustInstantiate(ctx, runtime)
 
-	_, err := wasmEngine.NewHostModuleBuilder("trealla").
+	_, err := runtime.NewHostModuleBuilder("trealla").
 		NewFunctionBuilder().WithFunc(hostCall).Export("host-call").
 		NewFunctionBuilder().WithFunc(hostResume).Export("host-resume").
 		NewFunctionBuilder().WithFunc(hostPushAnswer).Export("host-push-answer").
@@ -31,12 +52,52 @@ func init() {
 		panic(err)
 	}
 
-	wasmModule, err = wasmEngine.CompileModule(ctx, tplWASM)
-	if err != nil {
-		panic(err)
+	compile := func(ctx context.Context) wazero.CompiledModule {
+		module, err := runtime.CompileModule(ctx, tplWASM)
+		if err != nil {
+			panic(err)
+		}
+		return module
+	}
+	return &engine{
+		runtime: runtime,
+		module:  compile(ctx),
+		metered: sync.OnceValue(func() wazero.CompiledModule {
+			return compile(experimental.WithFunctionListenerFactory(ctx, inferenceCounter{}))
+		}),
+	}
+}
+
+// inferenceCounter counts the function calls made by the interpreter on behalf of a query.
+// It stops queries that exceed their [WithMaxInferences] limit by panicking with errInferenceLimit,
+// which wazero turns into an error.
+type inferenceCounter struct{}
+
+var errInferenceLimit = errors.New("trealla: inference limit exceeded")
+
+// NewFunctionListener implements experimental.FunctionListenerFactory.
+func (inferenceCounter) NewFunctionListener(api.FunctionDefinition) experimental.FunctionListener {
+	return inferenceCounter{}
+}
+
+// Before implements experimental.FunctionListener.
+func (inferenceCounter) Before(ctx context.Context, _ api.Module, _ api.FunctionDefinition, _ []uint64, _ experimental.StackIterator) {
+	q, ok := ctx.Value(queryContext{}).(*query)
+	if !ok {
+		return
+	}
+	q.inferences++
+	if q.maxInferences > 0 && q.inferences > q.maxInferences {
+		panic(errInferenceLimit)
 	}
 }
 
+// After implements experimental.FunctionListener.
+func (inferenceCounter) After(context.Context, api.Module, api.FunctionDefinition, []uint64) {}
+
+// Abort implements experimental.FunctionListener.
+func (inferenceCounter) Abort(context.Context, api.Module, api.FunctionDefinition, error) {}
+
 var (
 	wasmFalse uint32 = 0
 	wasmTrue  uint32 = 1
@@ -47,3 +108,46 @@ const (
 	align    = 1
 	pageSize = 64 * 1024
 )
+
+// limitedMemory is a wasm linear memory that refuses to grow past max bytes.
+type limitedMemory struct {
+	buf      []byte
+	max      uint64
+	exceeded bool // set when growing fails
+}
+
+// Allocate implements experimental.MemoryAllocator.
+func (mem *limitedMemory) Allocate(capacity, max uint64) experimental.LinearMemory {
+	mem.max = min(mem.max, max)
+	mem.buf = make([]byte, 0, min(capacity, mem.max))
+	return mem
+}
+
+// Reallocate implements experimental.LinearMemory.
+func (mem *limitedMemory) Reallocate(size uint64) []byte {
+	if size > mem.max {
+		mem.exceeded = true
+		return nil
+	}
+	if size > uint64(cap(mem.buf)) {
+		grown := make([]byte, size, min(max(size, 2*uint64(cap(mem.buf))), mem.max))
+		copy(grown, mem.buf)
+		mem.buf = grown
+		return mem.buf
+	}
+	mem.buf = mem.buf[:size]
+	return mem.buf
+}
+
+// Free implements experimental.LinearMemory.
+func (mem *limitedMemory) Free() {
+	mem.buf = nil
+}
+
+// minMemory returns the initial memory size of the interpreter in bytes.
+func minMemory() uint64 {
+	for _, mem := range wasmEngine.module.ExportedMemories() {
+		return uint64(mem.Min()) * pageSize
+	}
+	return 0
+}
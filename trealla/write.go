@@ -0,0 +1,467 @@
+package trealla
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MarshalOptions configures how [MarshalOptions.Marshal] writes terms.
+// The options mirror those of write_term/2.
+//
+// Unlike [Marshal], which always writes terms in canonical form, MarshalOptions
+// writes operator terms using operator notation, so a rule is written as
+// a :- b, c instead of :-(a, ','(b, c)).
+type MarshalOptions struct {
+	// Operators is the operator table used to write operator terms.
+	// If nil, [StandardOperators] is used.
+	// Use [CurrentOperators] to include operators defined by a program.
+	Operators *Operators
+	// IgnoreOps writes every compound in canonical form, like the ignore_ops(true) option.
+	IgnoreOps bool
+	// Quoted quotes atoms and strings so that the output can be read back, like the quoted(true) option.
+	Quoted bool
+	// MaxDepth limits the depth of nested terms, like the max_depth(N) option.
+	// Deeper subterms are written as ... and lists are truncated to MaxDepth elements.
+	// Zero means no limit.
+	MaxDepth int
+	// Indent, if not empty, writes rules over multiple lines, like portray_clause/1:
+	// each goal of the body is written on its own line, prefixed with Indent.
+	Indent string
+}
+
+// Marshal returns the Prolog text representation of term.
+func (opts MarshalOptions) Marshal(term Term) (string, error) {
+	w := termWriter{opts: opts, ops: opts.Operators}
+	if w.ops == nil {
+		w.ops = &Operators{prefix: prefixOps, infix: infixOps}
+	}
+	if c, ok := term.(Compound); ok && opts.Indent != "" && w.isClause(c) {
+		if err := w.clause(c); err != nil {
+			return "", err
+		}
+		return w.sb.String(), nil
+	}
+	if err := w.write(term, 1200, 1); err != nil {
+		return "", err
+	}
+	return w.sb.String(), nil
+}
+
+type termWriter struct {
+	opts MarshalOptions
+	ops  *Operators
+	sb   strings.Builder
+}
+
+// emit writes text, separated by a space from the previous text if they would otherwise read as one token.
+func (w *termWriter) emit(text string) {
+	if text == "" {
+		return
+	}
+	if w.sb.Len() > 0 {
+		last, _ := utf8.DecodeLastRuneInString(w.sb.String())
+		first, _ := utf8.DecodeRuneInString(text)
+		if isAlnum(last) && isAlnum(first) || isSymbolChar(last) && isSymbolChar(first) {
+			w.sb.WriteByte(' ')
+		}
+	}
+	w.sb.WriteString(text)
+}
+
+func isAlnum(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func startsAlnum(text string) bool {
+	r, _ := utf8.DecodeRuneInString(text)
+	return isAlnum(r)
+}
+
+func isSymbolChar(r rune) bool {
+	return r < utf8.RuneSelf && strings.IndexByte(symbolChars, byte(r)) >= 0
+}
+
+func (w *termWriter) write(term Term, max, depth int) error {
+	if w.opts.MaxDepth > 0 && depth > w.opts.MaxDepth {
+		w.emit("...")
+		return nil
+	}
+
+	term, err := marshalProlog(term)
+	if err != nil {
+		return err
+	}
+
+	switch x := term.(type) {
+	case Atom:
+		// operators are bracketed when they are operands
+		if w.ops.priority(x) > 0 && max < 999 {
+			w.emit("(")
+			w.emit(w.atom(x))
+			w.emit(")")
+			return nil
+		}
+		w.emit(w.atom(x))
+		return nil
+	case Variable:
+		if x.Name == "" {
+			w.emit("_")
+			return nil
+		}
+		w.emit(x.Name)
+		return nil
+	case string:
+		if w.opts.Quoted {
+			w.emit(escapeString(x))
+		} else {
+			w.emit(x)
+		}
+		return nil
+	case *big.Rat:
+		if w.opts.IgnoreOps {
+			return w.compound(Atom("rdiv").Of(x.Num(), x.Denom()), max, depth)
+		}
+		open := max < 400
+		if open {
+			w.emit("(")
+		}
+		w.emit(x.Num().String())
+		w.emit(" rdiv ")
+		w.emit(x.Denom().String())
+		if open {
+			w.emit(")")
+		}
+		return nil
+	case Compound:
+		return w.compound(x, max, depth)
+	case compoundStruct:
+		c, err := encodeCompoundStruct(term)
+		if err != nil {
+			return fmt.Errorf("trealla: error marshaling term %#v: %w", term, err)
+		}
+		return w.compound(c, max, depth)
+	case []Term:
+		return w.list(x, nil, depth)
+	}
+
+	rv := reflect.ValueOf(term)
+	if rv.IsValid() && (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array) {
+		elems := make([]Term, rv.Len())
+		for i := range elems {
+			elems[i] = rv.Index(i).Interface()
+		}
+		return w.list(elems, nil, depth)
+	}
+
+	text, err := marshal(term)
+	if err != nil {
+		return err
+	}
+	w.emit(text)
+	return nil
+}
+
+// atom returns the text of an atom, quoted if necessary.
+func (w *termWriter) atom(a Atom) string {
+	if !w.opts.Quoted {
+		return string(a)
+	}
+	switch a {
+	case "[]", "{}", "!", ";":
+		return string(a)
+	}
+	if a != "" && a != "." && strings.Trim(string(a), symbolChars) == "" {
+		return string(a)
+	}
+	return a.String()
+}
+
+func (w *termWriter) compound(c Compound, max, depth int) error {
+	if len(c.Args) == 0 {
+		w.emit(w.atom(c.Functor))
+		return nil
+	}
+
+	switch {
+	case c.Functor == "." && len(c.Args) == 2:
+		return w.partialList(c, depth)
+	case c.Functor == "{}" && len(c.Args) == 1 && !w.opts.IgnoreOps:
+		w.emit("{")
+		if err := w.write(c.Args[0], 1200, depth+1); err != nil {
+			return err
+		}
+		w.emit("}")
+		return nil
+	}
+
+	if !w.opts.IgnoreOps {
+		switch len(c.Args) {
+		case 1:
+			// -(1) and +(1) are written canonically, because - 1 is read as the number -1
+			if op, ok := w.ops.prefix[c.Functor]; ok && !isSignedNumber(c) {
+				return w.prefix(c, op, max, depth)
+			}
+			if op, ok := w.ops.postfix[c.Functor]; ok {
+				return w.postfix(c, op, max, depth)
+			}
+		case 2:
+			if op, ok := w.ops.infix[c.Functor]; ok {
+				return w.infix(c, op, max, depth)
+			}
+		}
+	}
+
+	w.emit(w.atom(c.Functor))
+	w.sb.WriteByte('(')
+	for i, arg := range c.Args {
+		if i > 0 {
+			w.sb.WriteString(", ")
+		}
+		if err := w.write(arg, 999, depth+1); err != nil {
+			return err
+		}
+	}
+	w.sb.WriteByte(')')
+	return nil
+}
+
+func (w *termWriter) infix(c Compound, op operator, max, depth int) error {
+	leftMax, rightMax := op.priority-1, op.priority-1
+	switch op.kind {
+	case xfy:
+		rightMax = op.priority
+	case yfx:
+		leftMax = op.priority
+	}
+	open := op.priority > max
+	if open {
+		w.emit("(")
+	}
+	if err := w.write(c.Args[0], leftMax, depth+1); err != nil {
+		return err
+	}
+	switch name := c.Functor; {
+	case name == ",":
+		w.sb.WriteString(", ")
+	case name == "|":
+		w.sb.WriteString(" | ")
+	case op.priority >= 700 || startsAlnum(string(name)):
+		w.sb.WriteByte(' ')
+		w.sb.WriteString(w.atom(name))
+		w.sb.WriteByte(' ')
+	default:
+		w.emit(w.atom(name))
+	}
+	if err := w.write(c.Args[1], rightMax, depth+1); err != nil {
+		return err
+	}
+	if open {
+		w.emit(")")
+	}
+	return nil
+}
+
+func (w *termWriter) prefix(c Compound, op operator, max, depth int) error {
+	argMax := op.priority
+	if op.kind == fx {
+		argMax--
+	}
+	open := op.priority > max
+	if open {
+		w.emit("(")
+	}
+	name := w.atom(c.Functor)
+	w.emit(name)
+	arg := c.Args[0]
+	// keep the argument from being read as the arguments of a compound or a negative number
+	if op.priority >= 700 || startsAlnum(name) || isNumber(arg) || w.priority(arg) > argMax {
+		w.sb.WriteByte(' ')
+	}
+	// - (1^2) is bracketed, because -1^2 is read as (-1)^2
+	signed := (c.Functor == "-" || c.Functor == "+") && w.startsWithNumber(arg, argMax)
+	if signed {
+		w.sb.WriteString(" (")
+		argMax = 1200
+	}
+	if err := w.write(arg, argMax, depth+1); err != nil {
+		return err
+	}
+	if signed {
+		w.sb.WriteByte(')')
+	}
+	if open {
+		w.emit(")")
+	}
+	return nil
+}
+
+func (w *termWriter) postfix(c Compound, op operator, max, depth int) error {
+	argMax := op.priority
+	if op.kind == xf {
+		argMax--
+	}
+	open := op.priority > max
+	if open {
+		w.emit("(")
+	}
+	if err := w.write(c.Args[0], argMax, depth+1); err != nil {
+		return err
+	}
+	w.emit(w.atom(c.Functor))
+	if open {
+		w.emit(")")
+	}
+	return nil
+}
+
+// priority returns the priority of term when written as an operand.
+func (w *termWriter) priority(term Term) int {
+	switch x := term.(type) {
+	case Atom:
+		return w.ops.priority(x)
+	case *big.Rat:
+		if !w.opts.IgnoreOps {
+			return 400
+		}
+	case Compound:
+		if w.opts.IgnoreOps {
+			return 0
+		}
+		switch len(x.Args) {
+		case 1:
+			if x.Functor == "{}" {
+				return 0
+			}
+			return max(w.ops.prefix[x.Functor].priority, w.ops.postfix[x.Functor].priority)
+		case 2:
+			if x.Functor == "." {
+				return 0
+			}
+			return w.ops.infix[x.Functor].priority
+		}
+	}
+	return 0
+}
+
+// startsWithNumber reports whether term, written as an operand with priority max, begins with a number.
+func (w *termWriter) startsWithNumber(term Term, max int) bool {
+	switch x := term.(type) {
+	case *big.Rat:
+		return max >= 400
+	case Compound:
+		if w.opts.IgnoreOps || w.priority(x) > max {
+			return false
+		}
+		switch len(x.Args) {
+		case 1:
+			if _, ok := w.ops.prefix[x.Functor]; ok {
+				return false
+			}
+			if op, ok := w.ops.postfix[x.Functor]; ok {
+				argMax := op.priority
+				if op.kind == xf {
+					argMax--
+				}
+				return w.startsWithNumber(x.Args[0], argMax)
+			}
+		case 2:
+			if op, ok := w.ops.infix[x.Functor]; ok && x.Functor != "." {
+				leftMax := op.priority - 1
+				if op.kind == yfx {
+					leftMax = op.priority
+				}
+				return w.startsWithNumber(x.Args[0], leftMax)
+			}
+		}
+		return false
+	}
+	return isNumber(term)
+}
+
+func (w *termWriter) list(elems []Term, tail Term, depth int) error {
+	w.emit("[")
+	for i, elem := range elems {
+		if w.opts.MaxDepth > 0 && i >= w.opts.MaxDepth {
+			w.sb.WriteString("|...")
+			tail = nil
+			break
+		}
+		if i > 0 {
+			w.sb.WriteString(", ")
+		}
+		if err := w.write(elem, 999, depth+1); err != nil {
+			return err
+		}
+	}
+	if tail != nil {
+		w.sb.WriteByte('|')
+		if err := w.write(tail, 999, depth+1); err != nil {
+			return err
+		}
+	}
+	w.sb.WriteByte(']')
+	return nil
+}
+
+// partialList writes a list made of '.'/2 compounds, like [a, b|T].
+func (w *termWriter) partialList(c Compound, depth int) error {
+	var elems []Term
+	var tail Term = c
+	for {
+		cons, ok := tail.(Compound)
+		if !ok || cons.Functor != "." || len(cons.Args) != 2 {
+			break
+		}
+		elems = append(elems, cons.Args[0])
+		tail = cons.Args[1]
+	}
+	if rest, ok := tail.([]Term); ok {
+		return w.list(append(elems, rest...), nil, depth)
+	}
+	return w.list(elems, tail, depth)
+}
+
+func (w *termWriter) isClause(c Compound) bool {
+	return len(c.Args) == 2 && (c.Functor == ":-" || c.Functor == "-->") && !w.opts.IgnoreOps
+}
+
+// clause writes a rule with each goal of its body on a separate line.
+func (w *termWriter) clause(c Compound) error {
+	if err := w.write(c.Args[0], 1199, 1); err != nil {
+		return err
+	}
+	w.sb.WriteByte(' ')
+	w.sb.WriteString(w.atom(c.Functor))
+	body := c.Args[1]
+	for {
+		w.sb.WriteByte('\n')
+		w.sb.WriteString(w.opts.Indent)
+		conj, ok := body.(Compound)
+		if !ok || conj.Functor != "," || len(conj.Args) != 2 {
+			break
+		}
+		if err := w.write(conj.Args[0], 999, 2); err != nil {
+			return err
+		}
+		w.sb.WriteByte(',')
+		body = conj.Args[1]
+	}
+	return w.write(body, 999, 2)
+}
+
+func isNumber(term Term) bool {
+	switch term.(type) {
+	case int64, float64, *big.Int, *big.Rat, int, uint64, uint, float32:
+		return true
+	}
+	return false
+}
+
+// isSignedNumber reports whether c is - or + applied to a number.
+func isSignedNumber(c Compound) bool {
+	return (c.Functor == "-" || c.Functor == "+") && isNumber(c.Args[0])
+}
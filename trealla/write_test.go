@@ -0,0 +1,156 @@
+package trealla_test
+
+import (
+	"context"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/trealla-prolog/go/trealla"
+)
+
+func TestMarshalOptions(t *testing.T) {
+	x, y := trealla.Variable{Name: "X"}, trealla.Variable{Name: "Y"}
+	quoted := trealla.MarshalOptions{Quoted: true}
+
+	tests := []struct {
+		name string
+		opts trealla.MarshalOptions
+		term trealla.Term
+		want string
+	}{
+		{"rule", quoted, trealla.Atom(":-").Of(trealla.Atom("a"), trealla.Atom(",").Of(trealla.Atom("b"), trealla.Atom("c"))), "a :- b, c"},
+		{"arithmetic", quoted, trealla.Atom("is").Of(x, trealla.Atom("*").Of(trealla.Atom("+").Of(int64(1), int64(2)), int64(3))), "X is (1+2)*3"},
+		{"left assoc", quoted, trealla.Atom("-").Of(trealla.Atom("-").Of(int64(1), int64(2)), int64(3)), "1-2-3"},
+		{"right assoc", quoted, trealla.Atom("-").Of(int64(1), trealla.Atom("-").Of(int64(2), int64(3))), "1-(2-3)"},
+		{"negative", quoted, trealla.Atom("-").Of(int64(1), int64(-1)), "1- -1"},
+		{"prefix number", quoted, trealla.Atom("-").Of(int64(1)), "-(1)"},
+		{"prefix negative number", quoted, trealla.Atom("-").Of(int64(-1)), "-(-1)"},
+		{"prefix plus number", quoted, trealla.Atom("+").Of(1.5), "+(1.5)"},
+		{"prefix", quoted, trealla.Atom(`\+`).Of(trealla.Atom("foo").Of(x)), `\+ foo(X)`},
+		{"prefix operand number", quoted, trealla.Atom("-").Of(trealla.Atom("^").Of(int64(1), int64(2))), "- (1^2)"},
+		{"prefix operand float", quoted, trealla.Atom("+").Of(trealla.Atom("*").Of(1.5, x)), "+ (1.5*X)"},
+		{"prefix operand atom", quoted, trealla.Atom("-").Of(trealla.Atom("^").Of(trealla.Atom("a"), int64(2))), "-a^2"},
+		{"float", quoted, trealla.Atom("f").Of(1.0, -2.0, float32(3)), "f(1.0, -2.0, 3.0)"},
+		{"prefix parens", quoted, trealla.Atom("-").Of(trealla.Atom("+").Of(int64(1), int64(2))), "- (1+2)"},
+		{"arg parens", quoted, trealla.Atom("f").Of(trealla.Atom(",").Of(trealla.Atom("a"), trealla.Atom("b"))), "f((a, b))"},
+		{"if-then-else", quoted, trealla.Atom(";").Of(trealla.Atom("->").Of(trealla.Atom("a"), trealla.Atom("b")), trealla.Atom("c")), "a -> b ; c"},
+		{"directive", quoted, trealla.Atom(":-").Of(trealla.Atom("dynamic").Of(trealla.Atom("/").Of(trealla.Atom("foo"), int64(1)))), ":- dynamic(foo/1)"},
+		{"module", quoted, trealla.Atom(":").Of(trealla.Atom("lists"), trealla.Atom("append").Of(x, y)), "lists:append(X, Y)"},
+		{"quoted", quoted, trealla.Atom("f").Of(trealla.Atom("Hello"), "str", trealla.Atom("[]"), trealla.Atom(","), trealla.Atom("=..")), `f('Hello', "str", [], ',', =..)`},
+		{"unquoted", trealla.MarshalOptions{}, trealla.Atom("f").Of(trealla.Atom("Hello world"), "str"), "f(Hello world, str)"},
+		{"operator atom", quoted, trealla.Atom("=").Of(trealla.Atom("-"), trealla.Atom("-")), "(-) = (-)"},
+		{"list", quoted, []trealla.Term{int64(1), trealla.Atom("a").Of(trealla.Atom("-").Of(x, y))}, "[1, a(X-Y)]"},
+		{"partial list", quoted, trealla.Atom(".").Of(int64(1), trealla.Atom(".").Of(int64(2), x)), "[1, 2|X]"},
+		{"curly", quoted, trealla.Atom("{}").Of(trealla.Atom(",").Of(x, y)), "{X, Y}"},
+		{"rational", quoted, trealla.Atom("*").Of(big.NewRat(1, 3), int64(2)), "1 rdiv 3*2"},
+		{"rational parens", quoted, trealla.Atom("^").Of(big.NewRat(1, 3), int64(2)), "(1 rdiv 3)^2"},
+		{"ignore ops", trealla.MarshalOptions{IgnoreOps: true, Quoted: true}, trealla.Atom(":-").Of(trealla.Atom("a"), trealla.Atom(",").Of(trealla.Atom("b"), trealla.Atom("c"))), ":-(a, ','(b, c))"},
+		{"max depth", trealla.MarshalOptions{MaxDepth: 2}, trealla.Atom("f").Of(trealla.Atom("g").Of(trealla.Atom("h").Of(x))), "f(g(...))"},
+		{"max depth list", trealla.MarshalOptions{MaxDepth: 3}, []trealla.Term{int64(1), int64(2), int64(3), int64(4)}, "[1, 2, 3|...]"},
+		{"indent", trealla.MarshalOptions{Quoted: true, Indent: "    "},
+			trealla.Atom(":-").Of(trealla.Atom("foo").Of(x), trealla.Atom(",").Of(trealla.Atom("bar").Of(x), trealla.Atom(",").Of(trealla.Atom(";").Of(trealla.Atom("a"), trealla.Atom("b")), trealla.Atom("!")))),
+			"foo(X) :-\n    bar(X),\n    (a ; b),\n    !"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.opts.Marshal(tc.term)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("bad marshal.\nwant: %s\n got: %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestMarshalOptionsRoundTrip(t *testing.T) {
+	texts := []string{
+		"a :- b, (c -> d ; e), \\+ f",
+		"X is -(1) + - 2 * (3 - 4) ** 5",
+		"f(- (-), [a|T], {x}, 'hello world', \"str\\n\")",
+		"- (1 rdiv 3)",
+		":- dynamic(foo/1)",
+		"- (1^2)",
+		"-(1)^2",
+		"- (1.0*2+3)",
+		"f(1.0, -2.0, 1.5e300)",
+		"a = \\+ b",
+		"f((a :- b), (:-))",
+		"1 - (-1) - (- 1) - -(1)",
+		"x^y^z",
+		"- a ^ b",
+		"(- a) ^ b",
+	}
+	opts := trealla.MarshalOptions{Quoted: true}
+	for _, text := range texts {
+		t.Run(text, func(t *testing.T) {
+			want, err := trealla.Parse(text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			written, err := opts.Marshal(want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := trealla.Parse(written)
+			if err != nil {
+				t.Fatalf("can't read back %q: %v", written, err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("round trip mismatch. wrote: %s\nwant: %#v\n got: %#v", written, want, got)
+			}
+		})
+	}
+}
+
+func TestCurrentOperators(t *testing.T) {
+	ctx := context.Background()
+	pl, err := trealla.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pl.Close()
+
+	if err := pl.ConsultText(ctx, "user", ":- op(700, xfx, ===>). :- op(200, xf, done)."); err != nil {
+		t.Fatal(err)
+	}
+	ops, err := trealla.CurrentOperators(ctx, pl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := trealla.MarshalOptions{Operators: ops, Quoted: true}
+	term := trealla.Atom("===>").Of(trealla.Atom("done").Of(trealla.Atom("a")), trealla.Atom(":-").Of(trealla.Atom("b"), trealla.Atom("c")))
+	got, err := opts.Marshal(term)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "a done ===> (b :- c)"; got != want {
+		t.Errorf("bad marshal. want: %s got: %s", want, got)
+	}
+
+	// the interpreter can read it back
+	ans, err := pl.QueryOnce(ctx, "X = ("+got+"), X = (A ===> B).")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := ans.Solution["B"]; b == nil {
+		t.Error("missing B")
+	}
+
+	if err := ops.Add(0, "xfx", "===>"); err != nil {
+		t.Fatal(err)
+	}
+	got, err = opts.Marshal(term)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "===>(a done, (b :- c))"; got != want {
+		t.Errorf("bad marshal after removing op. want: %s got: %s", want, got)
+	}
+
+	if err := ops.Add(700, "xyz", "foo"); err == nil {
+		t.Error("expected error for invalid specifier")
+	}
+}
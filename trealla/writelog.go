@@ -0,0 +1,109 @@
+package trealla
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// txLog records the queries executed during a write transaction, in the order they ran.
+type txLog struct {
+	events  []logEvent
+	queries int
+}
+
+// logEvent is a query being started or advanced during a write transaction.
+type logEvent struct {
+	// Goal is the text of a query started by this event, including any bindings.
+	Goal string `json:"goal,omitempty"`
+	// Next is the query that Next was called on, counting from 1 in the order queries were started.
+	Next int `json:"next,omitempty"`
+}
+
+// add records the start of a query, returning its number for next.
+func (log *txLog) add(goal string) int {
+	log.events = append(log.events, logEvent{Goal: goal})
+	log.queries++
+	return log.queries
+}
+
+// next records a call to Next on query n.
+func (log *txLog) next(n int) {
+	log.events = append(log.events, logEvent{Next: n})
+}
+
+// commit appends the transaction to w as a line of JSON.
+func (log *txLog) commit(w io.Writer) error {
+	if len(log.events) == 0 {
+		return nil
+	}
+	bs, err := json.Marshal(log.events)
+	if err != nil {
+		return err
+	}
+	bs = append(bs, '\n')
+	_, err = w.Write(bs)
+	return err
+}
+
+// WithWriteLog configures the Pool to record write transactions to w.
+// Each transaction is written as a single line of JSON after it has been applied, listing in order the queries
+// started on the canonical interpreter (including those made by [Prolog.ConsultText], [Prolog.Consult], and [Prolog.Register])
+// and the calls to Next on them.
+// Transactions that return an error are logged as well, because their changes are kept.
+// Use [Pool.ReplayLog] to apply the log to a new Pool.
+//
+// Native Go predicates can't be logged, so register them again after replaying.
+// Files loaded with [Prolog.Consult] are logged by name and must still exist when replaying.
+func WithWriteLog(w io.Writer) PoolOption {
+	return func(pool *Pool) error {
+		pool.writeLog = w
+		return nil
+	}
+}
+
+// ReplayLog applies a log written by [WithWriteLog] to this Pool, one write transaction per logged transaction.
+// Queries are started and advanced with Next in the same order as the original,
+// including when a transaction interleaved calls to Next on several open queries.
+// Replayed transactions are not written to this Pool's own log.
+//
+// A truncated final transaction, such as one left behind by a crash, is ignored.
+func (pool *Pool) ReplayLog(r io.Reader) error {
+	ctx := context.Background()
+	dec := json.NewDecoder(r)
+	for {
+		var events []logEvent
+		err := dec.Decode(&events)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("trealla: failed to read log: %w", err)
+		}
+		_, err = pool.writeTx(ctx, false, func(pl Prolog) error {
+			var queries []Query
+			defer func() {
+				for _, q := range queries {
+					q.Close()
+				}
+			}()
+			// errors are replayed as well; they happened the first time too
+			for _, event := range events {
+				if event.Next == 0 {
+					queries = append(queries, pl.Query(ctx, event.Goal))
+					continue
+				}
+				if event.Next > len(queries) {
+					return fmt.Errorf("trealla: log calls Next on query %d before it was started", event.Next)
+				}
+				queries[event.Next-1].Next(ctx)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("trealla: failed to replay log: %w", err)
+		}
+	}
+}
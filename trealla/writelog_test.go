@@ -0,0 +1,114 @@
+package trealla_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/trealla-prolog/go/trealla"
+)
+
+func TestWriteLog(t *testing.T) {
+	ctx := context.Background()
+	var log bytes.Buffer
+	pool, err := trealla.NewPool(trealla.WithPoolSize(1), trealla.WithWriteLog(&log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	if err := pool.ConsultText(ctx, "user", ":- dynamic(fact/1). fact(1). fact(2). fact(3)."); err != nil {
+		t.Fatal(err)
+	}
+	err = pool.WriteTx(func(pl trealla.Prolog) error {
+		_, err := pl.QueryOnce(ctx, "assertz(fact(X)).", trealla.WithBind("X", int64(4)))
+		if err != nil {
+			return err
+		}
+		// only the first two facts are retracted
+		q := pl.Query(ctx, "retract(fact(_)).")
+		defer q.Close()
+		q.Next(ctx)
+		q.Next(ctx)
+		return q.Err()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// failed transactions aren't rolled back, so they are logged too
+	errFailed := errors.New("failed")
+	err = pool.WriteTx(func(pl trealla.Prolog) error {
+		if _, err := pl.QueryOnce(ctx, "assertz(fact(5))."); err != nil {
+			return err
+		}
+		return errFailed
+	})
+	if !errors.Is(err, errFailed) {
+		t.Fatal("unexpected error:", err)
+	}
+
+	facts := func(pl trealla.Prolog) []trealla.Term {
+		t.Helper()
+		ans, err := pl.QueryOnce(ctx, "findall(X, fact(X), Xs).")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ans.Solution["Xs"].([]trealla.Term)
+	}
+	want := []trealla.Term{int64(3), int64(4), int64(5)}
+	if got := facts(pool); !reflect.DeepEqual(got, want) {
+		t.Fatal("unexpected facts. want:", want, "got:", got)
+	}
+
+	// interleaved calls to Next on open queries are replayed in the same order
+	err = pool.WriteTx(func(pl trealla.Prolog) error {
+		q1 := pl.Query(ctx, "member(X, [a, b]), assertz(seen(q1, X)).")
+		defer q1.Close()
+		q2 := pl.Query(ctx, "member(X, [a, b]), assertz(seen(q2, X)).")
+		defer q2.Close()
+		for _, q := range []trealla.Query{q1, q2, q1, q2} {
+			q.Next(ctx)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := func(pl trealla.Prolog) any {
+		t.Helper()
+		ans, err := pl.QueryOnce(ctx, "findall(Q-X, seen(Q, X), Xs).")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ans.Solution["Xs"]
+	}
+	pair := func(q, x trealla.Atom) trealla.Term { return trealla.Atom("-").Of(q, x) }
+	wantSeen := []trealla.Term{pair("q1", "a"), pair("q2", "a"), pair("q1", "b"), pair("q2", "b")}
+	if got := seen(pool); !reflect.DeepEqual(got, wantSeen) {
+		t.Fatal("unexpected order. want:", wantSeen, "got:", got)
+	}
+
+	// simulate a crash in the middle of writing a transaction
+	log.WriteString(`[{"goal":"assertz(fact(6))."},{"ne`)
+
+	replica, err := trealla.NewPool(trealla.WithPoolSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replica.Close()
+	if err := replica.ReplayLog(bytes.NewReader(log.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if got := facts(replica); !reflect.DeepEqual(got, want) {
+		t.Error("replayed pool differs. want:", want, "got:", got)
+	}
+	if got := seen(replica); !reflect.DeepEqual(got, wantSeen) {
+		t.Error("replayed interleaved queries differ. want:", wantSeen, "got:", got)
+	}
+
+	if err := replica.ReplayLog(bytes.NewReader([]byte("garbage\n"))); err == nil {
+		t.Error("expected error for garbage log")
+	}
+}